@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// silenceMatcher is a matcher of Alertmanager's v2 silence API.
+type silenceMatcher struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	IsRegex bool   `json:"isRegex"`
+	IsEqual bool   `json:"isEqual"`
+}
+
+type silence struct {
+	Matchers  []silenceMatcher `json:"matchers"`
+	StartsAt  time.Time        `json:"startsAt"`
+	EndsAt    time.Time        `json:"endsAt"`
+	CreatedBy string           `json:"createdBy"`
+	Comment   string           `json:"comment"`
+}
+
+// exactMatchers returns equality matchers for all labels, as used for
+// silencing one alert.
+func exactMatchers(labels KV) labelMatchers {
+	var matchers labelMatchers
+	for _, pair := range labels.SortedPairs() {
+		matchers = append(matchers, &labelMatcher{Name: pair.Name, Type: matchEqual, Value: pair.Value})
+	}
+	return matchers
+}
+
+// toSilenceMatchers converts route-style matchers for the silence API.
+func toSilenceMatchers(matchers labelMatchers) []silenceMatcher {
+	var converted []silenceMatcher
+	for _, m := range matchers {
+		converted = append(converted, silenceMatcher{
+			Name:    m.Name,
+			Value:   m.Value,
+			IsRegex: m.Type == matchRegexp || m.Type == matchNotRegexp,
+			IsEqual: m.Type == matchEqual || m.Type == matchRegexp,
+		})
+	}
+	return converted
+}
+
+// createSilence creates a silence through the v2 API of the Alertmanager
+// at baseURL with client and returns its ID.
+func createSilence(ctx context.Context, client *http.Client, baseURL string, s silence) (string, error) {
+	body, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	endpoint := strings.TrimSuffix(baseURL, "/") + "/api/v2/silences"
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := client.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	responseData, _ := io.ReadAll(io.LimitReader(response.Body, 64<<10))
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("alertmanager returned %s: %s", response.Status, strings.TrimSpace(string(responseData)))
+	}
+
+	var created struct {
+		SilenceID string `json:"silenceID"`
+	}
+	if err := json.Unmarshal(responseData, &created); err != nil {
+		return "", fmt.Errorf("unexpected response from alertmanager: %w", err)
+	}
+	return created.SilenceID, nil
+}
+
+// silenceAPIURL returns the Alertmanager to create silences in:
+// alertmanager.url if set, and otherwise the one that sent the alert.
+func silenceAPIURL(cfg *Config, externalURL string) string {
+	if cfg.Alertmanager.URL != "" {
+		return cfg.Alertmanager.URL
+	}
+	return linkURL(externalURL)
+}
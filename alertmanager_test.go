@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeAlertmanager serves the silence API with handler.
+func fakeAlertmanager(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func testSilence() silence {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	return silence{
+		Matchers:  []silenceMatcher{{Name: "alertname", Value: "Disk", IsEqual: true}},
+		StartsAt:  start,
+		EndsAt:    start.Add(time.Hour),
+		CreatedBy: "alice",
+		Comment:   "maintenance",
+	}
+}
+
+func TestCreateSilence(t *testing.T) {
+	var received silence
+	srv := fakeAlertmanager(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/v2/silences" {
+			t.Errorf("request = %s %s, want POST /api/v2/silences", r.Method, r.URL.Path)
+		}
+		if got := r.Header.Get("Content-Type"); got != "application/json" {
+			t.Errorf("Content-Type = %q", got)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("decoding silence: %v", err)
+		}
+		io.WriteString(w, `{"silenceID":"4b1e5c2a"}`)
+	})
+
+	// A trailing slash on the base URL must not double up.
+	id, err := createSilence(context.Background(), srv.Client(), srv.URL+"/", testSilence())
+	if err != nil {
+		t.Fatalf("createSilence: %v", err)
+	}
+	if id != "4b1e5c2a" {
+		t.Errorf("silence ID = %q, want 4b1e5c2a", id)
+	}
+	want := testSilence()
+	if received.CreatedBy != want.CreatedBy || received.Comment != want.Comment ||
+		!received.StartsAt.Equal(want.StartsAt) || !received.EndsAt.Equal(want.EndsAt) ||
+		len(received.Matchers) != 1 || received.Matchers[0] != want.Matchers[0] {
+		t.Errorf("Alertmanager received %+v, want %+v", received, want)
+	}
+}
+
+func TestCreateSilenceErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		status  int
+		body    string
+		wantErr string
+	}{
+		{"rejected", http.StatusBadRequest, "silence invalid: missing matchers\n", "400 Bad Request: silence invalid: missing matchers"},
+		{"server error", http.StatusInternalServerError, "boom", "500 Internal Server Error: boom"},
+		{"not JSON", http.StatusOK, "<html>proxy login</html>", "unexpected response from alertmanager"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := fakeAlertmanager(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+				io.WriteString(w, tc.body)
+			})
+			id, err := createSilence(context.Background(), srv.Client(), srv.URL, testSilence())
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("createSilence = %q, %v; want an error containing %q", id, err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestSilenceForUsesConfiguredClient(t *testing.T) {
+	// A TLS server is only trusted by its own client, not the default one.
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"silenceID":"tls"}`)
+	}))
+	defer srv.Close()
+	cfg := defaultConfig()
+	cfg.httpClient = srv.Client()
+
+	id, err := silenceFor(context.Background(), cfg, srv.URL, discordUser{Username: "alice"}, testSilence().Matchers, time.Hour, "")
+	if err != nil || id != "tls" {
+		t.Fatalf("silenceFor = %q, %v; want tls", id, err)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// defaultSignatureHeader carries the HMAC-SHA256 signature of the body.
+const defaultSignatureHeader = "X-Signature-256"
+
+// inboundAuth holds the secrets requests to the webhook are checked
+// against, with the ones kept in files already read.
+type inboundAuth struct {
+	bearerToken     string
+	basicUsername   string
+	basicPassword   string
+	hmacSecret      string
+	signatureHeader string
+}
+
+// readSecret returns value, or the content of file without trailing line
+// breaks when file is set.
+func readSecret(where, value, file string) (string, error) {
+	if file == "" {
+		return value, nil
+	}
+	if value != "" {
+		return "", fmt.Errorf("%s and %s_file are mutually exclusive", where, where)
+	}
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("%s_file: %w", where, err)
+	}
+	secret := strings.TrimRight(string(raw), "\r\n")
+	if secret == "" {
+		return "", fmt.Errorf("%s_file: %s is empty", where, file)
+	}
+	return secret, nil
+}
+
+// newInboundAuth reads the secrets of the security section.
+func newInboundAuth(cfg SecurityConfig) (*inboundAuth, error) {
+	var a inboundAuth
+	var err error
+	if a.bearerToken, err = readSecret("security.bearer_token", cfg.BearerToken, cfg.BearerTokenFile); err != nil {
+		return nil, err
+	}
+	if a.basicPassword, err = readSecret("security.basic_auth.password", cfg.BasicAuth.Password, cfg.BasicAuth.PasswordFile); err != nil {
+		return nil, err
+	}
+	a.basicUsername = cfg.BasicAuth.Username
+	if (a.basicUsername == "") != (a.basicPassword == "") {
+		return nil, fmt.Errorf("security.basic_auth needs both username and password")
+	}
+	if a.hmacSecret, err = readSecret("security.webhook_secret", cfg.WebhookSecret, cfg.WebhookSecretFile); err != nil {
+		return nil, err
+	}
+	a.signatureHeader = cfg.SignatureHeader
+	if a.signatureHeader == "" {
+		a.signatureHeader = defaultSignatureHeader
+	}
+	return &a, nil
+}
+
+// secretEqual compares two secrets in constant time. Hashing first keeps
+// the time independent of their lengths too.
+func secretEqual(given, expected string) bool {
+	g := sha256.Sum256([]byte(given))
+	e := sha256.Sum256([]byte(expected))
+	return subtle.ConstantTimeCompare(g[:], e[:]) == 1
+}
+
+// checkCredentials checks the Authorization header. With both a bearer
+// token and basic auth configured either one is accepted. It returns the
+// reason for rejecting the request, or "" to accept it.
+func (a *inboundAuth) checkCredentials(r *http.Request) string {
+	if a.bearerToken == "" && a.basicUsername == "" {
+		return ""
+	}
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return "missing_credentials"
+	}
+	if a.bearerToken != "" {
+		scheme, token, _ := strings.Cut(header, " ")
+		if strings.EqualFold(scheme, "Bearer") && secretEqual(strings.TrimSpace(token), a.bearerToken) {
+			return ""
+		}
+	}
+	if a.basicUsername != "" {
+		username, password, ok := r.BasicAuth()
+		// Evaluate both comparisons so timing does not reveal which failed.
+		userOK := secretEqual(username, a.basicUsername)
+		passwordOK := secretEqual(password, a.basicPassword)
+		if ok && userOK && passwordOK {
+			return ""
+		}
+	}
+	return "invalid_credentials"
+}
+
+// checkSignature checks the HMAC-SHA256 signature of body, sent hex-encoded
+// with an optional "sha256=" prefix.
+func (a *inboundAuth) checkSignature(r *http.Request, body []byte) string {
+	header := strings.TrimSpace(r.Header.Get(a.signatureHeader))
+	if header == "" {
+		return "missing_signature"
+	}
+	signature, err := hex.DecodeString(strings.TrimPrefix(header, "sha256="))
+	if err != nil {
+		return "invalid_signature"
+	}
+	mac := hmac.New(sha256.New, []byte(a.hmacSecret))
+	mac.Write(body)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return "invalid_signature"
+	}
+	return ""
+}
+
+// maxRequestBodySize bounds the body of authenticated requests, which is
+// read into memory to check its signature and to decode it.
+const maxRequestBodySize = 10 << 20
+
+// reject answers a request that failed a check with 401.
+func (a *inboundAuth) reject(w http.ResponseWriter, r *http.Request, reason string) {
+	slog.Warn("Rejected unauthenticated request", "remote", r.RemoteAddr, "path", r.URL.Path, "reason", reason)
+	authFailures.WithLabelValues(reason).Inc()
+	if a.basicUsername != "" {
+		w.Header().Set("WWW-Authenticate", `Basic realm="alertmanager-discord"`)
+	} else if a.bearerToken != "" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	}
+	http.Error(w, "unauthorized", http.StatusUnauthorized)
+}
+
+// readBodyError answers a request whose body could not be read, with 413
+// when it exceeds maxRequestBodySize.
+func readBodyError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		slog.Warn("Rejected request body over the size limit", "limit", tooLarge.Limit)
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	slog.Warn("Failed to read request body", "error", err)
+	http.Error(w, "failed to read request body", http.StatusBadRequest)
+}
+
+// requireAuth rejects requests to next that fail the configured checks
+// with 401. Credentials are checked before the body is read; a signed body
+// is read here and handed on to next. Bodies over maxRequestBodySize are
+// rejected with 413.
+func requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+		a := requestConfig(r).auth
+		reason := a.checkCredentials(r)
+		if reason == "" && a.hmacSecret != "" {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				readBodyError(w, err)
+				return
+			}
+			reason = a.checkSignature(r, body)
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		if reason != "" {
+			a.reject(w, r, reason)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requireCredentials rejects requests to next without the configured
+// bearer token or basic auth credentials with 401. Unlike requireAuth it
+// does not require a signature, for the endpoints operators call by hand
+// without a body worth signing.
+func requireCredentials(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		a := requestConfig(r).auth
+		if reason := a.checkCredentials(r); reason != "" {
+			a.reject(w, r, reason)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// withAuth configures a bearer token and an HMAC secret.
+func withAuth(t *testing.T) {
+	t.Helper()
+	cfg := defaultConfig()
+	cfg.auth = &inboundAuth{bearerToken: "token", hmacSecret: "secret", signatureHeader: defaultSignatureHeader}
+	withConfig(t, cfg)
+}
+
+func sign(body string) string {
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// echoBody answers with the body it was handed.
+func echoBody(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		readBodyError(w, err)
+		return
+	}
+	w.Write(body)
+}
+
+func TestRequireAuth(t *testing.T) {
+	withAuth(t)
+	const body = `{"status":"firing"}`
+
+	for _, tc := range []struct {
+		name      string
+		token     string
+		signature string
+		body      string
+		want      int
+	}{
+		{"token and signature", "token", sign(body), body, http.StatusOK},
+		{"hex signature without prefix", "token", strings.TrimPrefix(sign(body), "sha256="), body, http.StatusOK},
+		{"missing signature", "token", "", body, http.StatusUnauthorized},
+		{"signature of another body", "token", sign("{}"), body, http.StatusUnauthorized},
+		{"garbled signature", "token", "sha256=xyz", body, http.StatusUnauthorized},
+		{"wrong token", "other", sign(body), body, http.StatusUnauthorized},
+		{"missing token", "", sign(body), body, http.StatusUnauthorized},
+		{"body too large", "token", sign(body), strings.Repeat("x", maxRequestBodySize+1), http.StatusRequestEntityTooLarge},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tc.body))
+			if tc.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			if tc.signature != "" {
+				r.Header.Set(defaultSignatureHeader, tc.signature)
+			}
+			w := httptest.NewRecorder()
+			requireAuth(echoBody)(w, r)
+			if w.Code != tc.want {
+				t.Fatalf("status = %d, want %d", w.Code, tc.want)
+			}
+			if tc.want == http.StatusOK && w.Body.String() != tc.body {
+				t.Errorf("handler got body %q, want %q", w.Body, tc.body)
+			}
+			if tc.want == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != "Bearer" {
+				t.Errorf("WWW-Authenticate = %q, want Bearer", w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestRequireAuthLimitsUnsignedBody(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.auth = &inboundAuth{bearerToken: "token", signatureHeader: defaultSignatureHeader}
+	withConfig(t, cfg)
+
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(strings.Repeat("x", maxRequestBodySize+1)))
+	r.Header.Set("Authorization", "Bearer token")
+	w := httptest.NewRecorder()
+	requireAuth(echoBody)(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want 413", w.Code)
+	}
+}
+
+func TestRequireCredentialsNeedsNoSignature(t *testing.T) {
+	withAuth(t)
+
+	for _, tc := range []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"token", "token", http.StatusOK},
+		{"wrong token", "other", http.StatusUnauthorized},
+		{"missing token", "", http.StatusUnauthorized},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, reloadPath, nil)
+			if tc.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			w := httptest.NewRecorder()
+			requireCredentials(func(w http.ResponseWriter, r *http.Request) {})(w, r)
+			if w.Code != tc.want {
+				t.Errorf("status = %d, want %d", w.Code, tc.want)
+			}
+		})
+	}
+}
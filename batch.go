@@ -0,0 +1,153 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Discord limits for a single webhook message.
+const (
+	discordMaxEmbeds       = 10
+	discordMaxEmbedChars   = 6000
+	discordMaxContentChars = 2000
+)
+
+// renderedAlert is one alert rendered for Discord, the unit messages are
+// packed from.
+type renderedAlert struct {
+	// fingerprint identifies the alert in the message store, or is empty
+	// when the message needs not be remembered.
+	fingerprint string
+	embeds      DiscordEmbeds
+	content     string
+	mentions    mentionSet
+	// buttons are the links shown as a row of buttons below the message.
+	buttons []alertLink
+}
+
+// embedLength counts the characters of embed that count towards Discord's
+// per-message limit.
+func embedLength(embed DiscordEmbed) int {
+	n := utf8.RuneCountInString(embed.Title) + utf8.RuneCountInString(embed.Description)
+	for _, field := range embed.Fields {
+		n += utf8.RuneCountInString(field.Name) + utf8.RuneCountInString(field.Value)
+	}
+	if embed.Footer != nil {
+		n += utf8.RuneCountInString(embed.Footer.Text)
+	}
+	return n
+}
+
+// alertBatch is the alerts that share one Discord message.
+type alertBatch struct {
+	alerts   []renderedAlert
+	embeds   int
+	chars    int
+	rows     int
+	contents []string
+	mentions mentionSet
+}
+
+// messageContent joins the mentions and the template contents of a batch.
+func messageContent(mentions mentionSet, contents []string) string {
+	if mention := mentions.content(); mention != "" {
+		contents = append([]string{mention}, contents...)
+	}
+	return strings.Join(contents, "\n")
+}
+
+// fits reports whether alert can join the batch without exceeding maxAlerts
+// or Discord's limits.
+func (b *alertBatch) fits(alert renderedAlert, maxAlerts, maxEmbeds int) bool {
+	if len(b.alerts) == 0 {
+		return true
+	}
+	if len(b.alerts) >= maxAlerts || b.embeds+len(alert.embeds) > maxEmbeds {
+		return false
+	}
+	if len(alert.buttons) > 0 && b.rows >= discordMaxActionRows {
+		return false
+	}
+	chars := 0
+	for _, embed := range alert.embeds {
+		chars += embedLength(embed)
+	}
+	if b.chars+chars > discordMaxEmbedChars {
+		return false
+	}
+	contents := b.contents
+	if alert.content != "" && !containsString(contents, alert.content) {
+		contents = append(append([]string(nil), contents...), alert.content)
+	}
+	var mentions mentionSet
+	mentions.add(b.mentions)
+	mentions.add(alert.mentions)
+	return utf8.RuneCountInString(messageContent(mentions, contents)) <= discordMaxContentChars
+}
+
+func (b *alertBatch) add(alert renderedAlert) {
+	b.alerts = append(b.alerts, alert)
+	b.embeds += len(alert.embeds)
+	if len(alert.buttons) > 0 {
+		b.rows++
+	}
+	for _, embed := range alert.embeds {
+		b.chars += embedLength(embed)
+	}
+	if alert.content != "" && !containsString(b.contents, alert.content) {
+		b.contents = append(b.contents, alert.content)
+	}
+	b.mentions.add(alert.mentions)
+}
+
+// message builds the Discord message of the batch. fingerprints holds the
+// fingerprint of the alert shown in each embed, or is nil when no alert
+// needs to be remembered.
+func (b *alertBatch) message() (message DiscordMessage, fingerprints []string) {
+	remember := false
+	for i, alert := range b.alerts {
+		message.Embeds = append(message.Embeds, alert.embeds...)
+		for range alert.embeds {
+			fingerprints = append(fingerprints, alert.fingerprint)
+		}
+		remember = remember || alert.fingerprint != ""
+		if len(alert.buttons) > 0 {
+			title := ""
+			if b.rows > 1 {
+				title = alert.embeds[0].Title
+			}
+			message.Components = append(message.Components, linkButtonRow(alert.buttons, title, i))
+		}
+	}
+	message.Content = messageContent(b.mentions, b.contents)
+	message.AllowedMentions = b.mentions.allowed()
+	if !remember {
+		fingerprints = nil
+	}
+	return message, fingerprints
+}
+
+// packAlerts splits alerts into as few messages as possible, keeping their
+// order. A message holds at most maxAlerts alerts and stays within
+// maxEmbeds embeds, Discord's 6000 characters of embed text,
+// 2000 characters of content and 5 rows of buttons. The embeds of one alert
+// are never split up.
+func packAlerts(alerts []renderedAlert, maxAlerts int, maxEmbeds int) []*alertBatch {
+	if maxEmbeds > discordMaxEmbeds {
+		maxEmbeds = discordMaxEmbeds
+	}
+
+	var batches []*alertBatch
+	current := &alertBatch{}
+	for _, alert := range alerts {
+		if !current.fits(alert, maxAlerts, maxEmbeds) {
+			batches = append(batches, current)
+			current = &alertBatch{}
+		}
+		current.add(alert)
+	}
+	if len(current.alerts) > 0 {
+		batches = append(batches, current)
+	}
+	return batches
+}
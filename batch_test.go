@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testAlerts returns n rendered alerts, each with embeds embeds of chars
+// characters of description.
+func testAlerts(n, embeds, chars int, modify func(i int, a *renderedAlert)) []renderedAlert {
+	alerts := make([]renderedAlert, n)
+	for i := range alerts {
+		alerts[i].fingerprint = fmt.Sprintf("%016x", i)
+		for j := 0; j < embeds; j++ {
+			alerts[i].embeds = append(alerts[i].embeds, DiscordEmbed{Description: strings.Repeat("x", chars)})
+		}
+		if modify != nil {
+			modify(i, &alerts[i])
+		}
+	}
+	return alerts
+}
+
+func TestPackAlerts(t *testing.T) {
+	withButtons := func(i int, a *renderedAlert) {
+		a.buttons = []alertLink{{name: "Runbook", url: "https://example.com"}}
+	}
+	for _, tc := range []struct {
+		name                 string
+		alerts               []renderedAlert
+		maxAlerts, maxEmbeds int
+		want                 []int
+	}{
+		{"one message", testAlerts(3, 1, 10, nil), 10, 10, []int{3}},
+		{"10 embeds", testAlerts(12, 1, 10, nil), 20, 10, []int{10, 2}},
+		{"max_embeds above Discord's limit", testAlerts(12, 1, 10, nil), 20, 15, []int{10, 2}},
+		{"max_embeds", testAlerts(5, 1, 10, nil), 20, 2, []int{2, 2, 1}},
+		{"max alerts per message", testAlerts(7, 1, 10, nil), 3, 10, []int{3, 3, 1}},
+		{"embeds of one alert stay together", testAlerts(6, 3, 10, nil), 10, 10, []int{3, 3}},
+		{"6000 characters", testAlerts(5, 1, 2500, nil), 10, 10, []int{2, 2, 1}},
+		{"exactly 6000 characters", testAlerts(3, 1, 2000, nil), 10, 10, []int{3}},
+		{"oversized alert gets its own message", testAlerts(3, 1, 7000, nil), 10, 10, []int{1, 1, 1}},
+		{"5 button rows", testAlerts(7, 1, 10, withButtons), 10, 10, []int{5, 2}},
+		{"alerts without buttons share the message", testAlerts(7, 1, 10, func(i int, a *renderedAlert) {
+			if i < 5 {
+				withButtons(i, a)
+			}
+		}), 10, 10, []int{7}},
+		{"2000 characters of content", testAlerts(3, 1, 10, func(i int, a *renderedAlert) {
+			a.content = strings.Repeat(string(rune('a'+i)), 900)
+		}), 10, 10, []int{2, 1}},
+		{"repeated content counts once", testAlerts(3, 1, 10, func(i int, a *renderedAlert) {
+			a.content = strings.Repeat("a", 900)
+		}), 10, 10, []int{3}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []int
+			for _, batch := range packAlerts(tc.alerts, tc.maxAlerts, tc.maxEmbeds) {
+				got = append(got, len(batch.alerts))
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("alerts per message = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAlertBatchMessage(t *testing.T) {
+	alerts := testAlerts(2, 2, 10, func(i int, a *renderedAlert) {
+		a.embeds[0].Title = fmt.Sprintf("alert %d", i)
+		a.buttons = []alertLink{{name: "Runbook", url: "https://example.com"}}
+		a.content = "check the dashboard"
+		a.mentions = mentionSet{roles: []string{"123"}}
+	})
+	alerts[1].fingerprint = ""
+
+	batches := packAlerts(alerts, 10, 10)
+	if len(batches) != 1 {
+		t.Fatalf("got %d messages, want 1", len(batches))
+	}
+	message, fingerprints := batches[0].message()
+
+	if len(message.Embeds) != 4 || len(message.Components) != 2 {
+		t.Errorf("got %d embeds and %d button rows, want 4 and 2", len(message.Embeds), len(message.Components))
+	}
+	if want := []string{alerts[0].fingerprint, alerts[0].fingerprint, "", ""}; !reflect.DeepEqual(fingerprints, want) {
+		t.Errorf("fingerprints = %q, want %q", fingerprints, want)
+	}
+	if want := "<@&123>\ncheck the dashboard"; message.Content != want {
+		t.Errorf("content = %q, want %q", message.Content, want)
+	}
+}
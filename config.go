@@ -0,0 +1,690 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config mirrors config/alertmanager-discord.yml.
+type Config struct {
+	Server       ServerConfig       `yaml:"server"`
+	Discord      DiscordConfig      `yaml:"discord"`
+	Alertmanager AlertmanagerConfig `yaml:"alertmanager"`
+	Routing      RoutingConfig      `yaml:"routing"`
+	Mentions     []MentionConfig    `yaml:"mentions"`
+	Escalation   EscalationConfig   `yaml:"escalation"`
+	Alerts       AlertsConfig       `yaml:"alerts"`
+	Templates    TemplatesConfig    `yaml:"templates"`
+	Queue        QueueConfig        `yaml:"queue"`
+	State        StateConfig        `yaml:"state"`
+	Security     SecurityConfig     `yaml:"security"`
+	Logging      LoggingConfig      `yaml:"logging"`
+	Health       HealthConfig       `yaml:"health"`
+	Metrics      MetricsConfig      `yaml:"metrics"`
+
+	router         *alertRouter
+	mentions       []mentionRule
+	templates      *embedTemplates
+	escalation     []escalationTier
+	interactionKey ed25519.PublicKey
+	auth           *inboundAuth
+	sources        *sourceFilter
+	httpClient     *http.Client
+}
+
+type ServerConfig struct {
+	ListenAddress string `yaml:"listen_address"`
+	Verbose       bool   `yaml:"verbose"`
+	// Timeout is the request timeout in seconds.
+	Timeout int `yaml:"timeout"`
+	// DeliveryMode is deliveryModeSync or deliveryModeAsync. It defaults to
+	// async when the delivery queue is enabled.
+	DeliveryMode string `yaml:"delivery_mode"`
+	// ShutdownTimeout bounds how long a shutdown waits for requests being
+	// handled and messages being delivered; 0 waits for them all.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// WatchConfigFile reloads the configuration when the file changes, in
+	// addition to SIGHUP and POST /-/reload.
+	WatchConfigFile bool      `yaml:"watch_config_file"`
+	TLS             TLSConfig `yaml:"tls"`
+}
+
+// TLSConfig serves the listener over HTTPS when CertFile is set. The files
+// are re-read when they change, so certificates can be renewed without a
+// restart.
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ClientCAFile verifies client certificates against these CAs; it
+	// makes client certificates mandatory unless ClientAuthType says
+	// otherwise.
+	ClientCAFile string `yaml:"client_ca_file"`
+	// ClientAuthType is the name of a tls.ClientAuthType, such as
+	// RequireAndVerifyClientCert.
+	ClientAuthType string `yaml:"client_auth_type"`
+	// MinVersion is TLS10, TLS11, TLS12 or TLS13.
+	MinVersion string `yaml:"min_version"`
+	// CipherSuites restricts the TLS 1.2 cipher suites, by Go name.
+	CipherSuites []string `yaml:"cipher_suites"`
+}
+
+const (
+	// deliveryModeSync sends to Discord before answering Alertmanager and
+	// reports failures with a 5xx status so Alertmanager retries.
+	deliveryModeSync = "sync"
+	// deliveryModeAsync answers 202 Accepted once the messages are in the
+	// delivery queue.
+	deliveryModeAsync = "async"
+)
+
+type DiscordConfig struct {
+	WebhookURL string `yaml:"webhook_url"`
+	// AdditionalWebhooks is a comma-separated list of webhook URLs.
+	AdditionalWebhooks string `yaml:"additional_webhooks"`
+	// Webhooks are additional named webhook URLs that routes can refer to.
+	Webhooks   map[string]string `yaml:"webhooks"`
+	Username   string            `yaml:"username"`
+	AvatarURL  string            `yaml:"avatar_url"`
+	Formatting FormattingConfig  `yaml:"formatting"`
+	RateLimit  RateLimitConfig   `yaml:"rate_limit"`
+	// BotToken authenticates Discord API calls webhooks cannot make, such
+	// as starting a thread on a message.
+	BotToken string        `yaml:"bot_token"`
+	Threads  ThreadsConfig `yaml:"threads"`
+	// ApplicationWebhooks names the webhooks created by a Discord
+	// application. Only those can send buttons; the others show links in
+	// an embed field.
+	ApplicationWebhooks []string           `yaml:"application_webhooks"`
+	Interactions        InteractionsConfig `yaml:"interactions"`
+	HTTP                HTTPClientConfig   `yaml:"http"`
+}
+
+// HTTPClientConfig configures the HTTP client used for all requests to
+// Discord. A zero timeout or limit means none.
+type HTTPClientConfig struct {
+	// ProxyURL overrides the HTTPS_PROXY and NO_PROXY environment
+	// variables, e.g. http://proxy.example.com:3128.
+	ProxyURL string `yaml:"proxy_url"`
+	// CAFile is a PEM bundle trusted in addition to the system roots.
+	CAFile                string        `yaml:"ca_file"`
+	DialTimeout           time.Duration `yaml:"dial_timeout"`
+	TLSHandshakeTimeout   time.Duration `yaml:"tls_handshake_timeout"`
+	ResponseHeaderTimeout time.Duration `yaml:"response_header_timeout"`
+	// Timeout limits a whole request, including reading the response.
+	Timeout             time.Duration `yaml:"timeout"`
+	MaxIdleConns        int           `yaml:"max_idle_conns"`
+	MaxIdleConnsPerHost int           `yaml:"max_idle_conns_per_host"`
+	MaxConnsPerHost     int           `yaml:"max_conns_per_host"`
+	IdleConnTimeout     time.Duration `yaml:"idle_conn_timeout"`
+}
+
+// InteractionsConfig enables the endpoint that receives button clicks and
+// slash commands from Discord.
+type InteractionsConfig struct {
+	// PublicKey is the hex-encoded public key of the Discord application,
+	// used to verify interaction requests. Setting it enables the
+	// endpoint and the Silence button on application webhooks.
+	PublicKey string `yaml:"public_key"`
+	// ApplicationID registers the /silence command at startup; it needs
+	// bot_token.
+	ApplicationID string `yaml:"application_id"`
+	// SilenceDuration is the silence duration suggested by the Silence
+	// button and used by /silence when none is given.
+	SilenceDuration time.Duration `yaml:"silence_duration"`
+	// AllowedRoles are the IDs of the server roles that may create
+	// silences. Empty allows everyone who can use the button or command.
+	AllowedRoles []string `yaml:"allowed_roles"`
+}
+
+// AlertmanagerConfig describes the Alertmanager the alerts come from.
+type AlertmanagerConfig struct {
+	// URL is the base URL of the Alertmanager UI used for silence links.
+	// It defaults to the externalURL of each notification.
+	URL string `yaml:"url"`
+}
+
+// ThreadsConfig posts the notifications of one Alertmanager group into a
+// single Discord thread.
+type ThreadsConfig struct {
+	// Mode is threadModeMessage, threadModeForum or "" to post every
+	// notification into the channel.
+	Mode string `yaml:"mode"`
+	// AutoArchiveDuration is the inactivity in minutes after which Discord
+	// archives threads started on messages: 60, 1440, 4320 or 10080.
+	AutoArchiveDuration int `yaml:"auto_archive_duration"`
+}
+
+const (
+	// threadModeMessage starts a thread on the first message of a group.
+	// Webhooks cannot do that, so it needs discord.bot_token.
+	threadModeMessage = "message"
+	// threadModeForum creates a forum post per group, for webhooks of
+	// forum channels.
+	threadModeForum = "forum"
+)
+
+type FormattingConfig struct {
+	MaxEmbeds            int `yaml:"max_embeds"`
+	MaxDescriptionLength int `yaml:"max_description_length"`
+	MaxFieldValueLength  int `yaml:"max_field_value_length"`
+	MaxTitleLength       int `yaml:"max_title_length"`
+	MaxLabels            int `yaml:"max_labels"`
+	// RateLimitDelay is the minimum delay in milliseconds between two
+	// messages to the same webhook.
+	RateLimitDelay int `yaml:"rate_limit_delay"`
+}
+
+// RateLimitConfig controls how 429 responses from Discord are retried.
+type RateLimitConfig struct {
+	// MaxRetries is how often a rate limited request is retried in place.
+	MaxRetries int `yaml:"max_retries"`
+	// MaxWait is the longest Retry-After that is waited out in place.
+	MaxWait time.Duration `yaml:"max_wait"`
+}
+
+// RoutingConfig selects webhooks per alert based on its labels.
+type RoutingConfig struct {
+	// Default lists the webhooks for alerts that match no route. It
+	// defaults to webhook_url plus additional_webhooks.
+	Default []string      `yaml:"default"`
+	Routes  []RouteConfig `yaml:"routes"`
+}
+
+// MentionConfig pings roles, users or @here for firing alerts whose labels
+// match all Matchers. Every matching entry adds its mentions.
+type MentionConfig struct {
+	Matchers []string `yaml:"matchers"`
+	// Webhooks limits the mentions to these webhooks; role and user IDs
+	// are specific to a Discord server.
+	Webhooks []string `yaml:"webhooks"`
+	Roles    []string `yaml:"roles"`
+	Users    []string `yaml:"users"`
+	Here     bool     `yaml:"here"`
+	Everyone bool     `yaml:"everyone"`
+}
+
+// EscalationConfig re-posts firing alerts that nobody acknowledged.
+type EscalationConfig struct {
+	// Interval is how often firing alerts are checked for escalation.
+	Interval time.Duration          `yaml:"interval"`
+	Tiers    []EscalationTierConfig `yaml:"tiers"`
+}
+
+// EscalationTierConfig re-posts a firing alert whose labels match all
+// Matchers to Webhooks once it fired for After without being resolved,
+// acknowledged or silenced from Discord. Every tier is sent at most once
+// per alert.
+type EscalationTierConfig struct {
+	Matchers []string      `yaml:"matchers"`
+	After    time.Duration `yaml:"after"`
+	Webhooks []string      `yaml:"webhooks"`
+	Roles    []string      `yaml:"roles"`
+	Users    []string      `yaml:"users"`
+	Here     bool          `yaml:"here"`
+	Everyone bool          `yaml:"everyone"`
+}
+
+type RouteConfig struct {
+	// Matchers use Alertmanager syntax, e.g. team="gpu" or severity=~"critical|page".
+	Matchers []string `yaml:"matchers"`
+	Webhooks []string `yaml:"webhooks"`
+	// Continue keeps evaluating later routes after this one matched.
+	Continue bool `yaml:"continue"`
+}
+
+// TemplatesConfig holds Go text/template overrides for the embed layout.
+// Empty templates keep the built-in rendering.
+type TemplatesConfig struct {
+	Title       string                `yaml:"title"`
+	Description string                `yaml:"description"`
+	Content     string                `yaml:"content"`
+	Color       string                `yaml:"color"`
+	Footer      string                `yaml:"footer"`
+	Fields      []FieldTemplateConfig `yaml:"fields"`
+}
+
+type FieldTemplateConfig struct {
+	Name   string `yaml:"name"`
+	Value  string `yaml:"value"`
+	Inline bool   `yaml:"inline"`
+}
+
+type AlertsConfig struct {
+	// IndividualMessages posts every alert as a message of its own instead
+	// of packing alerts into as few messages as possible.
+	IndividualMessages bool `yaml:"individual_messages"`
+	// MaxAlertsPerMessage caps how many alerts are packed into one message.
+	MaxAlertsPerMessage int  `yaml:"max_alerts_per_message"`
+	SendResolved        bool `yaml:"send_resolved"`
+	GroupByStatus       bool `yaml:"group_by_status"`
+	// EditOnResolve turns the firing message green when its alert resolves
+	// instead of posting a separate resolved message.
+	EditOnResolve bool `yaml:"edit_on_resolve"`
+}
+
+// QueueConfig controls the on-disk delivery queue. The queue is disabled
+// and messages are sent synchronously when Directory is empty.
+type QueueConfig struct {
+	Directory string `yaml:"directory"`
+	Workers   int    `yaml:"workers"`
+	// SegmentSize is the size in bytes after which a new segment is started.
+	SegmentSize    int64         `yaml:"segment_size"`
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+	// MaxAge is how long a message is retried before it is dropped; zero
+	// retries forever.
+	MaxAge time.Duration `yaml:"max_age"`
+}
+
+// StateConfig controls where the IDs of posted Discord messages and group
+// threads are kept. Without a directory they are only kept in memory.
+type StateConfig struct {
+	Directory string `yaml:"directory"`
+	// Retention is how long a message is remembered after it was posted.
+	Retention time.Duration `yaml:"retention"`
+}
+
+// SecurityConfig protects the webhook from requests that do not come from
+// Alertmanager. Every secret can also be read from a file.
+type SecurityConfig struct {
+	// BearerToken and BasicAuth match Alertmanager's http_config; when
+	// both are set either one is accepted.
+	BearerToken     string          `yaml:"bearer_token"`
+	BearerTokenFile string          `yaml:"bearer_token_file"`
+	BasicAuth       BasicAuthConfig `yaml:"basic_auth"`
+	// WebhookSecret requires an HMAC-SHA256 signature of the body in
+	// SignatureHeader, in addition to any credentials.
+	WebhookSecret     string `yaml:"webhook_secret"`
+	WebhookSecretFile string `yaml:"webhook_secret_file"`
+	SignatureHeader   string `yaml:"signature_header"`
+	// AllowedIPs and DeniedIPs are addresses or CIDRs. Denied addresses
+	// are rejected even if allowed; an empty allow list allows the rest.
+	AllowedIPs []string `yaml:"allowed_ips"`
+	DeniedIPs  []string `yaml:"denied_ips"`
+	// TrustedProxies may report the client address in X-Forwarded-For.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+}
+
+type BasicAuthConfig struct {
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"password_file"`
+}
+
+type LoggingConfig struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+	File   string `yaml:"file"`
+}
+
+type HealthConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	Endpoint string `yaml:"endpoint"`
+	// CacheTTL is how long a webhook probe result is reused by /readyz.
+	CacheTTL time.Duration `yaml:"cache_ttl"`
+	// Timeout bounds the webhook probes of one readiness check.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+type MetricsConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	Endpoint string `yaml:"endpoint"`
+	// Receivers lists the Alertmanager receivers counted under their own
+	// name. The receiver comes from the request, so any other value is
+	// counted as "other" to keep the number of series bounded.
+	Receivers []string `yaml:"receivers"`
+}
+
+// reservedPath reports whether path is served by a fixed endpoint.
+func reservedPath(path string) bool {
+	return path == acksPath || path == reloadPath || path == interactionsPath
+}
+
+// defaultConfig returns the settings used when no config file is given.
+// They match the values that used to be hard-coded, except that alerts are
+// now packed into as few messages as possible.
+func defaultConfig() *Config {
+	return &Config{
+		Server: ServerConfig{
+			ListenAddress:   defaultListenAddress,
+			Timeout:         30,
+			ShutdownTimeout: 30 * time.Second,
+			TLS: TLSConfig{
+				MinVersion: "TLS12",
+			},
+		},
+		Discord: DiscordConfig{
+			Formatting: FormattingConfig{
+				MaxEmbeds:            10,
+				MaxDescriptionLength: 200,
+				MaxFieldValueLength:  150,
+				MaxTitleLength:       150,
+				MaxLabels:            3,
+				RateLimitDelay:       200,
+			},
+			RateLimit: RateLimitConfig{
+				MaxRetries: 5,
+				MaxWait:    time.Minute,
+			},
+			Threads: ThreadsConfig{
+				AutoArchiveDuration: 1440,
+			},
+			Interactions: InteractionsConfig{
+				SilenceDuration: 2 * time.Hour,
+			},
+			HTTP: HTTPClientConfig{
+				DialTimeout:           10 * time.Second,
+				TLSHandshakeTimeout:   10 * time.Second,
+				ResponseHeaderTimeout: 30 * time.Second,
+				Timeout:               time.Minute,
+				MaxIdleConns:          100,
+				MaxIdleConnsPerHost:   10,
+				IdleConnTimeout:       90 * time.Second,
+			},
+		},
+		Escalation: EscalationConfig{
+			Interval: 30 * time.Second,
+		},
+		Alerts: AlertsConfig{
+			MaxAlertsPerMessage: 10,
+			SendResolved:        true,
+			GroupByStatus:       true,
+		},
+		Queue: QueueConfig{
+			Workers:        2,
+			SegmentSize:    8 << 20,
+			InitialBackoff: time.Second,
+			MaxBackoff:     5 * time.Minute,
+			MaxAge:         24 * time.Hour,
+		},
+		State: StateConfig{
+			Retention: 7 * 24 * time.Hour,
+		},
+		Logging: LoggingConfig{
+			Level:  "info",
+			Format: "text",
+		},
+		Health: HealthConfig{
+			Enabled:  true,
+			Endpoint: "/health",
+			CacheTTL: time.Minute,
+			Timeout:  5 * time.Second,
+		},
+		Metrics: MetricsConfig{
+			Endpoint: "/metrics",
+		},
+	}
+}
+
+var envReferenceRe = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnvReferences replaces ${VAR} references with the value of the
+// environment variable. Bare $VAR is left alone so Go templates survive.
+func expandEnvReferences(s string) string {
+	return envReferenceRe.ReplaceAllStringFunc(s, func(ref string) string {
+		return os.Getenv(envReferenceRe.FindStringSubmatch(ref)[1])
+	})
+}
+
+// loadConfig reads the YAML file at path on top of the defaults, applies
+// the flags and prepares the routing table. An empty path only uses the
+// defaults and flags.
+func loadConfig(path string) (*Config, error) {
+	cfg := defaultConfig()
+	if path != "" {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading config file: %w", err)
+		}
+
+		decoder := yaml.NewDecoder(strings.NewReader(expandEnvReferences(string(raw))))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("parsing config file %s: %w", path, err)
+		}
+	}
+	cfg.applyFlags()
+
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	if err := cfg.compile(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return cfg, nil
+}
+
+// compile builds the lookup structures derived from the settings.
+func (c *Config) compile() error {
+	webhooks := map[string]string{defaultWebhookName: c.Discord.WebhookURL}
+	legacy := []string{defaultWebhookName}
+	for i, additionalWebhook := range strings.Split(c.Discord.AdditionalWebhooks, ",") {
+		additionalWebhook = strings.TrimSpace(additionalWebhook)
+		if !isNotBlankOrEmpty(additionalWebhook) {
+			continue
+		}
+		ok, err := validWebhookURL(additionalWebhook)
+		if err != nil {
+			return fmt.Errorf("discord.additional_webhooks: entry %d is not a valid URL", i+1)
+		}
+		if ok {
+			name := fmt.Sprintf("additional-%d", i+1)
+			webhooks[name] = additionalWebhook
+			legacy = append(legacy, name)
+		}
+	}
+	for name, webhook := range c.Discord.Webhooks {
+		if _, ok := webhooks[name]; ok {
+			return fmt.Errorf("discord.webhooks: name %q is reserved", name)
+		}
+		if !isNotBlankOrEmpty(webhook) {
+			return fmt.Errorf("discord.webhooks: %q has no URL", name)
+		}
+		if _, err := validWebhookURL(webhook); err != nil {
+			return fmt.Errorf("discord.webhooks: %q is not a valid URL", name)
+		}
+		webhooks[name] = webhook
+	}
+
+	router, err := newAlertRouter(webhooks, legacy, c.Routing)
+	if err != nil {
+		return err
+	}
+	c.router = router
+
+	if err := router.checkNames("discord.application_webhooks", c.Discord.ApplicationWebhooks); err != nil {
+		return err
+	}
+
+	mentions, err := newMentionRules(c.Mentions, router)
+	if err != nil {
+		return err
+	}
+	c.mentions = mentions
+
+	escalation, err := newEscalationTiers(c.Escalation, router)
+	if err != nil {
+		return err
+	}
+	c.escalation = escalation
+
+	templates, err := newEmbedTemplates(c.Templates)
+	if err != nil {
+		return err
+	}
+	c.templates = templates
+
+	auth, err := newInboundAuth(c.Security)
+	if err != nil {
+		return err
+	}
+	c.auth = auth
+
+	sources, err := newSourceFilter(c.Security)
+	if err != nil {
+		return err
+	}
+	c.sources = sources
+
+	httpClient, err := newHTTPClient(c.Discord.HTTP)
+	if err != nil {
+		return err
+	}
+	c.httpClient = httpClient
+
+	if key := c.Discord.Interactions.PublicKey; key != "" {
+		raw, err := hex.DecodeString(key)
+		if err != nil || len(raw) != ed25519.PublicKeySize {
+			return fmt.Errorf("discord.interactions.public_key must be the hex-encoded public key of the Discord application")
+		}
+		c.interactionKey = ed25519.PublicKey(raw)
+	}
+	return nil
+}
+
+// applyFlags overrides config values with any non-empty flag or
+// environment variable, so the command line always wins.
+func (c *Config) applyFlags() {
+	if *webhookURL != "" {
+		c.Discord.WebhookURL = *webhookURL
+	}
+	if *additionalWebhookURLFlag != "" {
+		c.Discord.AdditionalWebhooks = *additionalWebhookURLFlag
+	}
+	if *queueDirectory != "" {
+		c.Queue.Directory = *queueDirectory
+	}
+	if *stateDirectory != "" {
+		c.State.Directory = *stateDirectory
+	}
+	if *listenAddress != "" {
+		c.Server.ListenAddress = *listenAddress
+	}
+	if *username != "" {
+		c.Discord.Username = *username
+	}
+	if *avatarURL != "" {
+		c.Discord.AvatarURL = *avatarURL
+	}
+	switch strings.ToLower(*verboseMode) {
+	case "on", "true", "1", "yes":
+		c.Server.Verbose = true
+	case "off", "false", "0", "no":
+		c.Server.Verbose = false
+	}
+	if *deliveryMode != "" {
+		c.Server.DeliveryMode = *deliveryMode
+	}
+	if c.Server.ListenAddress == "" {
+		c.Server.ListenAddress = defaultListenAddress
+	}
+	if c.Server.DeliveryMode == "" {
+		c.Server.DeliveryMode = deliveryModeSync
+		if c.Queue.Directory != "" {
+			c.Server.DeliveryMode = deliveryModeAsync
+		}
+	}
+}
+
+func validAutoArchiveDuration(minutes int) bool {
+	switch minutes {
+	case 60, 1440, 4320, 10080:
+		return true
+	}
+	return false
+}
+
+func (c *Config) validate() error {
+	f := c.Discord.Formatting
+	switch {
+	case f.MaxEmbeds < 1 || f.MaxEmbeds > 10:
+		return fmt.Errorf("discord.formatting.max_embeds must be between 1 and 10, got %d", f.MaxEmbeds)
+	case f.MaxTitleLength < 4 || f.MaxTitleLength > 256:
+		return fmt.Errorf("discord.formatting.max_title_length must be between 4 and 256, got %d", f.MaxTitleLength)
+	case f.MaxDescriptionLength < 4 || f.MaxDescriptionLength > 4096:
+		return fmt.Errorf("discord.formatting.max_description_length must be between 4 and 4096, got %d", f.MaxDescriptionLength)
+	case f.MaxFieldValueLength < 4 || f.MaxFieldValueLength > 1024:
+		return fmt.Errorf("discord.formatting.max_field_value_length must be between 4 and 1024, got %d", f.MaxFieldValueLength)
+	case c.Alerts.MaxAlertsPerMessage < 1:
+		return fmt.Errorf("alerts.max_alerts_per_message must be at least 1, got %d", c.Alerts.MaxAlertsPerMessage)
+	case f.MaxLabels < 0:
+		return fmt.Errorf("discord.formatting.max_labels must not be negative")
+	case f.RateLimitDelay < 0:
+		return fmt.Errorf("discord.formatting.rate_limit_delay must not be negative")
+	case c.Discord.RateLimit.MaxRetries < 0:
+		return fmt.Errorf("discord.rate_limit.max_retries must not be negative")
+	case c.Discord.RateLimit.MaxWait < 0:
+		return fmt.Errorf("discord.rate_limit.max_wait must not be negative")
+	case c.Discord.Threads.Mode != "" && c.Discord.Threads.Mode != threadModeMessage && c.Discord.Threads.Mode != threadModeForum:
+		return fmt.Errorf("discord.threads.mode must be %q, %q or empty, got %q", threadModeMessage, threadModeForum, c.Discord.Threads.Mode)
+	case c.Discord.Threads.Mode == threadModeMessage && c.Discord.BotToken == "":
+		return fmt.Errorf("discord.threads.mode %q requires discord.bot_token", threadModeMessage)
+	case c.Discord.Threads.Mode == threadModeMessage && !validAutoArchiveDuration(c.Discord.Threads.AutoArchiveDuration):
+		return fmt.Errorf("discord.threads.auto_archive_duration must be 60, 1440, 4320 or 10080, got %d", c.Discord.Threads.AutoArchiveDuration)
+	case c.Server.Timeout < 0:
+		return fmt.Errorf("server.timeout must not be negative")
+	case c.Server.ShutdownTimeout < 0:
+		return fmt.Errorf("server.shutdown_timeout must not be negative")
+	case c.Server.DeliveryMode != deliveryModeSync && c.Server.DeliveryMode != deliveryModeAsync:
+		return fmt.Errorf("server.delivery_mode must be %q or %q, got %q", deliveryModeSync, deliveryModeAsync, c.Server.DeliveryMode)
+	case c.Server.DeliveryMode == deliveryModeAsync && c.Queue.Directory == "":
+		return fmt.Errorf("server.delivery_mode %q requires queue.directory", deliveryModeAsync)
+	case c.Health.CacheTTL < 0 || c.Health.Timeout <= 0:
+		return fmt.Errorf("health.cache_ttl must not be negative and health.timeout must be positive")
+	case c.Health.Endpoint != "" && !strings.HasPrefix(c.Health.Endpoint, "/"):
+		return fmt.Errorf("health.endpoint must start with /, got %q", c.Health.Endpoint)
+	case c.Metrics.Enabled && (!strings.HasPrefix(c.Metrics.Endpoint, "/") || c.Metrics.Endpoint == "/"):
+		return fmt.Errorf("metrics.endpoint must be a path below /, got %q", c.Metrics.Endpoint)
+	case c.Metrics.Enabled && c.Health.Enabled && c.Metrics.Endpoint == c.Health.Endpoint:
+		return fmt.Errorf("metrics.endpoint and health.endpoint must differ")
+	case c.Metrics.Enabled && c.Health.Enabled && (c.Metrics.Endpoint == "/healthz" || c.Metrics.Endpoint == "/readyz"):
+		return fmt.Errorf("metrics.endpoint %q is taken by the health endpoints", c.Metrics.Endpoint)
+	case reservedPath(c.Health.Endpoint) || c.Metrics.Enabled && reservedPath(c.Metrics.Endpoint):
+		return fmt.Errorf("health.endpoint and metrics.endpoint must not be %s, %s or %s", acksPath, reloadPath, interactionsPath)
+	case c.Queue.Workers < 1:
+		return fmt.Errorf("queue.workers must be at least 1, got %d", c.Queue.Workers)
+	case c.Queue.SegmentSize < 1024:
+		return fmt.Errorf("queue.segment_size must be at least 1024 bytes, got %d", c.Queue.SegmentSize)
+	case c.Queue.InitialBackoff <= 0 || c.Queue.MaxBackoff < c.Queue.InitialBackoff:
+		return fmt.Errorf("queue.initial_backoff must be positive and not above queue.max_backoff")
+	case c.Queue.MaxAge < 0:
+		return fmt.Errorf("queue.max_age must not be negative")
+	case c.State.Retention <= 0:
+		return fmt.Errorf("state.retention must be positive")
+	case c.Escalation.Interval <= 0:
+		return fmt.Errorf("escalation.interval must be positive")
+	case c.Discord.Interactions.SilenceDuration <= 0:
+		return fmt.Errorf("discord.interactions.silence_duration must be positive")
+	case c.Discord.Interactions.ApplicationID != "" && c.Discord.BotToken == "":
+		return fmt.Errorf("discord.interactions.application_id requires discord.bot_token")
+	case c.Alertmanager.URL != "" && linkURL(c.Alertmanager.URL) == "":
+		return fmt.Errorf("alertmanager.url must be an http or https URL, got %q", c.Alertmanager.URL)
+	}
+	if err := c.Server.TLS.validate(); err != nil {
+		return err
+	}
+	if err := c.Discord.HTTP.validate(); err != nil {
+		return err
+	}
+	if _, err := parseLogLevel(c.Logging.Level); err != nil {
+		return fmt.Errorf("logging.level: %w", err)
+	}
+	if format := strings.ToLower(c.Logging.Format); format != "" && format != "text" && format != "json" {
+		return fmt.Errorf("logging.format must be \"text\" or \"json\", got %q", c.Logging.Format)
+	}
+	// A role name instead of an ID would lock everyone out.
+	if err := checkMentionIDs("discord.interactions.allowed_roles", c.Discord.Interactions.AllowedRoles, nil); err != nil {
+		return err
+	}
+	return nil
+}
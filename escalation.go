@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+)
+
+// escalationTier re-posts firing alerts that match its matchers and were
+// neither resolved nor acknowledged after some time.
+type escalationTier struct {
+	// key identifies the tier in the alert state, see escalationTierKey.
+	key      string
+	matchers labelMatchers
+	after    time.Duration
+	webhooks []string
+	mentions mentionSet
+}
+
+// escalation is an alert due for an escalation tier. number is the
+// position of the tier in the configuration, counted from 1.
+type escalation struct {
+	alert  trackedAlert
+	tier   escalationTier
+	number int
+}
+
+// escalationTierKey identifies a tier by its matchers, delay and webhooks,
+// so a reload that reorders, adds or removes tiers does not send a tier
+// again, or skip one, for alerts that are already firing. Changing the
+// mentions of a tier keeps its key.
+func escalationTierKey(tc EscalationTierConfig) string {
+	matchers := slices.Clone(tc.Matchers)
+	for i := range matchers {
+		matchers[i] = strings.TrimSpace(matchers[i])
+	}
+	slices.Sort(matchers)
+	webhooks := slices.Clone(tc.Webhooks)
+	slices.Sort(webhooks)
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%q\n%s\n%q", matchers, tc.After, webhooks)
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// newEscalationTiers compiles the escalation section. Webhook names are
+// checked against router.
+func newEscalationTiers(cfg EscalationConfig, router *alertRouter) ([]escalationTier, error) {
+	var tiers []escalationTier
+	for i, tc := range cfg.Tiers {
+		where := fmt.Sprintf("escalation.tiers[%d]", i)
+		matchers, err := parseLabelMatchers(tc.Matchers)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", where, err)
+		}
+		if tc.After <= 0 {
+			return nil, fmt.Errorf("%s: after must be positive", where)
+		}
+		if len(tc.Webhooks) == 0 {
+			return nil, fmt.Errorf("%s: no webhooks configured", where)
+		}
+		if err := router.checkNames(where, tc.Webhooks); err != nil {
+			return nil, err
+		}
+		if err := checkMentionIDs(where, tc.Roles, tc.Users); err != nil {
+			return nil, err
+		}
+		tiers = append(tiers, escalationTier{
+			key:      escalationTierKey(tc),
+			matchers: matchers,
+			after:    tc.After,
+			webhooks: tc.Webhooks,
+			mentions: mentionSet{roles: tc.Roles, users: tc.Users, here: tc.Here, everyone: tc.Everyone},
+		})
+	}
+	return tiers, nil
+}
+
+// runEscalations checks for alerts due for escalation every interval.
+func runEscalations(interval time.Duration) {
+	for range time.Tick(interval) {
+		cfg := config()
+		for _, e := range firingAlerts.escalate(cfg.escalation, time.Now()) {
+			sendEscalation(cfg, e)
+		}
+	}
+}
+
+// sendEscalation re-posts the alert of e to the webhooks of its tier with
+// the tier's mentions.
+func sendEscalation(cfg *Config, e escalation) {
+	tier := e.tier
+	alert := e.alert.alert()
+	alertManagerData := &AlertManagerData{
+		Receiver:     e.alert.Receiver,
+		Status:       "firing",
+		Alerts:       AlertManagerAlerts{alert},
+		CommonLabels: alert.Labels,
+		ExternalURL:  e.alert.ExternalURL,
+	}
+	firing, _ := humanizeDuration(time.Since(alert.StartsAt).Round(time.Second))
+	text := fmt.Sprintf("⏫ **Escalation %d**: firing for %s without acknowledgement", e.number, firing)
+	if mention := tier.mentions.content(); mention != "" {
+		text = mention + "\n" + text
+	}
+
+	for _, webhook := range tier.webhooks {
+		notificationLogger(alertManagerData).Info("Escalating unacknowledged alert",
+			"webhook", webhook, "fingerprint", alert.Fingerprint, "tier", e.number)
+		embed := buildAlertEmbed(cfg, alertManagerData, &alert, findColor(alert.Status))
+		buttons, fieldLinks := splitAlertLinks(cfg, webhook, alertLinks(cfg, alertManagerData, webhook, &alert))
+		addLinksField(&embed, fieldLinks)
+		message := DiscordMessage{
+			Content:         text,
+			Embeds:          DiscordEmbeds{embed},
+			AllowedMentions: tier.mentions.allowed(),
+		}
+		if len(buttons) > 0 {
+			message.Components = []DiscordComponent{linkButtonRow(buttons, "", 0)}
+		}
+		result := postMessageToDiscord(cfg, alertManagerData, &delivery{WebhookName: webhook, Message: message})
+		if result.allFailed() {
+			slog.Error("Failed to send escalation", "webhook", webhook, "fingerprint", alert.Fingerprint, "tier", e.number)
+		}
+	}
+}
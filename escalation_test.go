@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func testEscalationTiers(t *testing.T, configs ...EscalationTierConfig) []escalationTier {
+	t.Helper()
+	router, err := newAlertRouter(map[string]string{defaultWebhookName: testWebhookURL, "oncall": testWebhookURL}, []string{defaultWebhookName}, RoutingConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tiers, err := newEscalationTiers(EscalationConfig{Tiers: configs}, router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tiers
+}
+
+func escalatedNumbers(due []escalation) []int {
+	var numbers []int
+	for _, e := range due {
+		numbers = append(numbers, e.number)
+	}
+	return numbers
+}
+
+func TestEscalationTierKey(t *testing.T) {
+	base := EscalationTierConfig{Matchers: []string{`severity="critical"`, `team="db"`}, After: 15 * time.Minute, Webhooks: []string{"a", "b"}}
+	key := escalationTierKey(base)
+
+	same := base
+	same.Matchers = []string{` team="db"`, `severity="critical"`}
+	same.Webhooks = []string{"b", "a"}
+	same.Roles = []string{"123456789012345678"}
+	if got := escalationTierKey(same); got != key {
+		t.Errorf("key changed with the order of matchers and webhooks or the mentions: %s != %s", got, key)
+	}
+
+	for name, modify := range map[string]func(*EscalationTierConfig){
+		"matchers": func(tc *EscalationTierConfig) { tc.Matchers = []string{`severity="critical"`} },
+		"after":    func(tc *EscalationTierConfig) { tc.After = time.Hour },
+		"webhooks": func(tc *EscalationTierConfig) { tc.Webhooks = []string{"a"} },
+	} {
+		other := base
+		modify(&other)
+		if escalationTierKey(other) == key {
+			t.Errorf("key did not change with the %s", name)
+		}
+	}
+}
+
+func TestEscalateSurvivesReorderedTiers(t *testing.T) {
+	start := time.Now().Add(-time.Hour)
+	store := &alertStore{retention: time.Hour, alerts: map[string]*trackedAlert{
+		"f1": {Fingerprint: "f1", Labels: KV{"severity": "critical"}, StartsAt: start, UpdatedAt: time.Now()},
+	}}
+	early := EscalationTierConfig{Matchers: []string{`severity="critical"`}, After: 10 * time.Minute, Webhooks: []string{"oncall"}}
+	late := EscalationTierConfig{Matchers: []string{`severity="critical"`}, After: 30 * time.Minute, Webhooks: []string{"oncall"}}
+	added := EscalationTierConfig{Matchers: []string{`severity="critical"`}, After: 20 * time.Minute, Webhooks: []string{"oncall"}}
+
+	due := store.escalate(testEscalationTiers(t, early), start.Add(15*time.Minute))
+	if got := escalatedNumbers(due); len(got) != 1 || got[0] != 1 {
+		t.Fatalf("escalated tiers = %v, want [1]", got)
+	}
+
+	// A reload puts a new tier in front and moves the one already sent.
+	tiers := testEscalationTiers(t, added, late, early)
+	due = store.escalate(tiers, start.Add(40*time.Minute))
+	if got := escalatedNumbers(due); len(got) != 2 || got[0] != 1 || got[1] != 2 {
+		t.Fatalf("escalated tiers after reload = %v, want [1 2]", got)
+	}
+	if due[0].tier.after != added.After || due[1].tier.after != late.After {
+		t.Errorf("escalated the wrong tiers: after %v and %v", due[0].tier.after, due[1].tier.after)
+	}
+	if due = store.escalate(tiers, start.Add(50*time.Minute)); len(due) != 0 {
+		t.Errorf("tiers sent again: %v", escalatedNumbers(due))
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// webhookStatus is the result of probing one Discord webhook.
+type webhookStatus struct {
+	Name       string    `json:"name"`
+	OK         bool      `json:"ok"`
+	HTTPStatus int       `json:"http_status,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	CheckedAt  time.Time `json:"checked_at"`
+}
+
+type readinessReport struct {
+	Status   string          `json:"status"`
+	Webhooks []webhookStatus `json:"webhooks"`
+}
+
+// webhookProber checks that the configured Discord webhooks still exist and
+// that their tokens are valid, caching the results for a while so readiness
+// probes do not hammer Discord.
+type webhookProber struct {
+	mu sync.Mutex
+	// results are keyed by webhook URL, so a webhook whose URL changed on
+	// reload is probed again. Only the webhooks of the last check are kept.
+	results map[string]webhookStatus
+}
+
+var readinessProber = &webhookProber{results: make(map[string]webhookStatus)}
+
+// probeWebhook GETs the webhook URL. Discord answers 200 with the webhook object
+// for a valid token and 401 or 404 otherwise.
+func probeWebhook(ctx context.Context, client *http.Client, name, webhookURL string) webhookStatus {
+	status := webhookStatus{Name: name, CheckedAt: time.Now()}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, webhookURL, nil)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	response, err := client.Do(req)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+
+	status.HTTPStatus = response.StatusCode
+	status.OK = response.StatusCode == http.StatusOK
+	if !status.OK {
+		status.Error = fmt.Sprintf("unexpected status %d", response.StatusCode)
+	}
+	return status
+}
+
+// check returns the status of every configured webhook, probing those
+// whose cached result is older than the TTL of cfg.
+func (p *webhookProber) check(ctx context.Context, cfg *Config) []webhookStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	webhooks := cfg.router.webhooks
+	names := make([]string, 0, len(webhooks))
+	for name := range webhooks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.Health.Timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	statuses := make([]webhookStatus, len(names))
+	for i, name := range names {
+		cached, ok := p.results[webhooks[name]]
+		if ok && time.Since(cached.CheckedAt) < cfg.Health.CacheTTL {
+			cached.Name = name
+			statuses[i] = cached
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			statuses[i] = probeWebhook(ctx, cfg.httpClient, name, webhooks[name])
+		}(i, name)
+	}
+	wg.Wait()
+
+	results := make(map[string]webhookStatus, len(statuses))
+	for i, status := range statuses {
+		previous, ok := p.results[webhooks[names[i]]]
+		if !status.OK && (previous.OK || !ok) {
+			slog.Warn("Discord webhook is not ready", "webhook", status.Name, "discord_status", status.HTTPStatus, "error", status.Error)
+		}
+		results[webhooks[names[i]]] = status
+	}
+	p.results = results
+	return statuses
+}
+
+func handleLiveness(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"status":"ok"}` + "\n"))
+}
+
+func handleReadiness(w http.ResponseWriter, r *http.Request) {
+	report := readinessReport{Status: "ready", Webhooks: readinessProber.check(r.Context(), requestConfig(r))}
+	code := http.StatusOK
+	for _, status := range report.Webhooks {
+		if !status.OK {
+			report.Status = "not ready"
+			code = http.StatusServiceUnavailable
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(report)
+}
+
+// newServeMux registers the alert webhook and the health, metrics and
+// Discord interactions endpoints. Whether the health and metrics endpoints
+// are served, and where, is decided per request from its configuration, so
+// a reload can move or disable them. The source filter covers all endpoints
+// except the interactions endpoint, which Discord calls from its own
+// addresses and which is verified by its signature instead.
+func newServeMux() *http.ServeMux {
+	webhook := requireAuth(handleWebHook)
+	restricted := http.NewServeMux()
+	restricted.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		cfg := requestConfig(r)
+		switch path := r.URL.Path; {
+		case cfg.Health.Enabled && path == "/readyz":
+			handleReadiness(w, r)
+		case cfg.Health.Enabled && (path == "/healthz" || path == cfg.Health.Endpoint):
+			handleLiveness(w, r)
+		case cfg.Metrics.Enabled && path == cfg.Metrics.Endpoint:
+			metricsHandler.ServeHTTP(w, r)
+		default:
+			webhook(w, r)
+		}
+	})
+	restricted.HandleFunc(acksPath, requireInteractions(requireCredentials(handleAcks)))
+	restricted.HandleFunc(reloadPath, requireCredentials(handleReload))
+
+	mux := http.NewServeMux()
+	mux.Handle("/", restrictSources(restricted))
+	// Interactions can be turned on and off by a reload.
+	mux.HandleFunc(interactionsPath, requireInteractions(handleInteraction))
+	return mux
+}
+
+// runHealthcheck queries the liveness endpoint of a running instance and
+// returns the process exit code, for use as a container health check.
+func runHealthcheck() int {
+	host, port, err := net.SplitHostPort(config().Server.ListenAddress)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid listen address: %v\n", err)
+		return 1
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	scheme := "http"
+	if tlsCfg := config().Server.TLS; tlsCfg.enabled() {
+		// Only liveness is checked here, not the certificate. Offer the
+		// server's own certificate in case client certificates are
+		// required.
+		scheme = "https"
+		clientTLS := &tls.Config{InsecureSkipVerify: true}
+		if certificate, err := tls.LoadX509KeyPair(tlsCfg.CertFile, tlsCfg.KeyFile); err == nil {
+			clientTLS.Certificates = []tls.Certificate{certificate}
+		}
+		client.Transport = &http.Transport{TLSClientConfig: clientTLS}
+	}
+	response, err := client.Get(scheme + "://" + net.JoinHostPort(host, port) + "/healthz")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "health check failed: %v\n", err)
+		return 1
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "health check failed: status %d\n", response.StatusCode)
+		return 1
+	}
+	return 0
+}
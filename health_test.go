@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhookProberFollowsReloads(t *testing.T) {
+	valid := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer valid.Close()
+	revoked := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer revoked.Close()
+
+	prober := &webhookProber{results: make(map[string]webhookStatus)}
+	check := func(webhooks map[string]string) []webhookStatus {
+		cfg := testWebhookConfig(t, webhooks)
+		cfg.Health.CacheTTL = time.Hour
+		return prober.check(context.Background(), cfg)
+	}
+
+	if statuses := check(map[string]string{"team": valid.URL, "old": valid.URL}); !statuses[0].OK || !statuses[1].OK {
+		t.Fatalf("statuses = %+v, want both ready", statuses)
+	}
+	// A reload pointed the webhook at another URL within the cache TTL.
+	statuses := check(map[string]string{"team": revoked.URL})
+	if len(statuses) != 1 || statuses[0].OK {
+		t.Errorf("statuses after reload = %+v, want team not ready", statuses)
+	}
+	if len(prober.results) != 1 {
+		t.Errorf("prober keeps %d results, want only the current webhook", len(prober.results))
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// validate checks the settings of the outbound HTTP client.
+func (c HTTPClientConfig) validate() error {
+	switch {
+	case c.DialTimeout < 0:
+		return fmt.Errorf("discord.http.dial_timeout must not be negative")
+	case c.TLSHandshakeTimeout < 0:
+		return fmt.Errorf("discord.http.tls_handshake_timeout must not be negative")
+	case c.ResponseHeaderTimeout < 0:
+		return fmt.Errorf("discord.http.response_header_timeout must not be negative")
+	case c.Timeout < 0:
+		return fmt.Errorf("discord.http.timeout must not be negative")
+	case c.IdleConnTimeout < 0:
+		return fmt.Errorf("discord.http.idle_conn_timeout must not be negative")
+	case c.MaxIdleConns < 0 || c.MaxIdleConnsPerHost < 0 || c.MaxConnsPerHost < 0:
+		return fmt.Errorf("discord.http connection limits must not be negative")
+	}
+	if c.ProxyURL != "" {
+		proxy, err := url.Parse(c.ProxyURL)
+		if err != nil || proxy.Host == "" {
+			return fmt.Errorf("discord.http.proxy_url %q is not a valid URL", c.ProxyURL)
+		}
+		switch proxy.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return fmt.Errorf("discord.http.proxy_url must use http, https or socks5, got %q", proxy.Scheme)
+		}
+	}
+	return nil
+}
+
+// newHTTPClient returns the client all requests to Discord are made with.
+// Without a proxy_url the usual HTTPS_PROXY and NO_PROXY variables apply.
+func newHTTPClient(cfg HTTPClientConfig) (*http.Client, error) {
+	proxy := http.ProxyFromEnvironment
+	if cfg.ProxyURL != "" {
+		proxyURL, err := url.Parse(cfg.ProxyURL)
+		if err != nil {
+			return nil, err
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("discord.http.ca_file: %w", err)
+		}
+		// The bundle is added to the system roots, so a proxy that
+		// intercepts TLS does not have to cover every other host too.
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("discord.http.ca_file %s contains no certificates", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = roots
+	}
+
+	dialer := &net.Dialer{Timeout: cfg.DialTimeout, KeepAlive: 30 * time.Second}
+	return &http.Client{
+		Timeout: cfg.Timeout,
+		Transport: &http.Transport{
+			Proxy:                 proxy,
+			DialContext:           dialer.DialContext,
+			TLSClientConfig:       tlsConfig,
+			TLSHandshakeTimeout:   cfg.TLSHandshakeTimeout,
+			ResponseHeaderTimeout: cfg.ResponseHeaderTimeout,
+			ExpectContinueTimeout: time.Second,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          cfg.MaxIdleConns,
+			MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+			MaxConnsPerHost:       cfg.MaxConnsPerHost,
+			IdleConnTimeout:       cfg.IdleConnTimeout,
+		},
+	}, nil
+}
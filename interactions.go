@@ -0,0 +1,592 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// interactionsPath is the endpoint Discord sends interactions to; set it as
+// the Interactions Endpoint URL of the application.
+const interactionsPath = "/discord/interactions"
+
+// acksPath serves the acknowledged alerts as JSON.
+const acksPath = "/api/v1/acks"
+
+// Discord interaction and interaction response types.
+const (
+	interactionPing               = 1
+	interactionApplicationCommand = 2
+	interactionMessageComponent   = 3
+	interactionModalSubmit        = 5
+
+	responsePong                     = 1
+	responseChannelMessageWithSource = 4
+	responseUpdateMessage            = 7
+	responseModal                    = 9
+
+	// messageFlagEphemeral shows a reply only to the user who interacted.
+	messageFlagEphemeral = 1 << 6
+	// permissionManageMessages is the MANAGE_MESSAGES permission bit.
+	permissionManageMessages = 1 << 13
+
+	componentTypeTextInput = 4
+	textInputShort         = 1
+	textInputParagraph     = 2
+)
+
+// Prefixes of the custom_id of the buttons and modals of an alert. The
+// alert's fingerprint and the index of its embed follow, see
+// linkButtonRow.
+const (
+	silenceCustomIDPrefix = "silence:"
+	ackCustomIDPrefix     = "ack:"
+)
+
+// parseCustomID returns the fingerprint and embed index of a custom_id
+// that starts with prefix. The index is -1 when it is missing.
+func parseCustomID(customID, prefix string) (fingerprint string, index int) {
+	fingerprint, rest, found := strings.Cut(strings.TrimPrefix(customID, prefix), ":")
+	if !found {
+		return fingerprint, -1
+	}
+	index, err := strconv.Atoi(rest)
+	if err != nil {
+		return fingerprint, -1
+	}
+	return fingerprint, index
+}
+
+// silenceTimeout bounds the call to Alertmanager; Discord expects an answer
+// to an interaction within 3 seconds.
+const silenceTimeout = 2500 * time.Millisecond
+
+type discordUser struct {
+	ID         string `json:"id"`
+	Username   string `json:"username"`
+	GlobalName string `json:"global_name"`
+}
+
+func (u discordUser) name() string {
+	if u.GlobalName != "" {
+		return u.GlobalName
+	}
+	return u.Username
+}
+
+type commandOption struct {
+	Name  string          `json:"name"`
+	Value json.RawMessage `json:"value"`
+}
+
+type discordInteraction struct {
+	Type int `json:"type"`
+	Data struct {
+		Name       string             `json:"name"`
+		Options    []commandOption    `json:"options"`
+		CustomID   string             `json:"custom_id"`
+		Components []DiscordComponent `json:"components"`
+	} `json:"data"`
+	// Member is set for interactions in a server, User for direct
+	// messages.
+	Member *struct {
+		User  discordUser `json:"user"`
+		Roles []string    `json:"roles"`
+	} `json:"member"`
+	User    *discordUser `json:"user"`
+	Message *struct {
+		ID         string             `json:"id"`
+		Embeds     DiscordEmbeds      `json:"embeds"`
+		Components []DiscordComponent `json:"components"`
+	} `json:"message"`
+}
+
+func (i *discordInteraction) user() discordUser {
+	if i.Member != nil {
+		return i.Member.User
+	}
+	if i.User != nil {
+		return *i.User
+	}
+	return discordUser{Username: "unknown"}
+}
+
+// maySilence reports whether the user of the interaction has one of the
+// roles allowed to create silences. Outside a server the user has no roles.
+func (i *discordInteraction) maySilence(cfg *Config) bool {
+	allowed := cfg.Discord.Interactions.AllowedRoles
+	if len(allowed) == 0 {
+		return true
+	}
+	if i.Member == nil {
+		return false
+	}
+	for _, role := range i.Member.Roles {
+		if slices.Contains(allowed, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// option returns the string value of the named slash command option.
+func (i *discordInteraction) option(name string) string {
+	for _, o := range i.Data.Options {
+		if o.Name == name {
+			var value string
+			json.Unmarshal(o.Value, &value)
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// input returns the value of the named text input of a submitted modal.
+func (i *discordInteraction) input(customID string) string {
+	for _, row := range i.Data.Components {
+		for _, c := range row.Components {
+			if c.CustomID == customID {
+				return strings.TrimSpace(c.Value)
+			}
+		}
+	}
+	return ""
+}
+
+type interactionResponse struct {
+	Type int         `json:"type"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+// interactionMessage is the message of an interaction response. Fields left
+// empty are kept unchanged when the response updates a message.
+type interactionMessage struct {
+	Content         string                  `json:"content,omitempty"`
+	Embeds          DiscordEmbeds           `json:"embeds,omitempty"`
+	Components      []DiscordComponent      `json:"components,omitempty"`
+	Flags           int                     `json:"flags,omitempty"`
+	AllowedMentions *DiscordAllowedMentions `json:"allowed_mentions,omitempty"`
+}
+
+type interactionModal struct {
+	CustomID   string             `json:"custom_id"`
+	Title      string             `json:"title"`
+	Components []DiscordComponent `json:"components"`
+}
+
+// interactionsEnabled reports whether Discord interactions are handled.
+func (c *Config) interactionsEnabled() bool {
+	return c.Discord.Interactions.PublicKey != ""
+}
+
+// requireInteractions answers 404 while interactions are not configured.
+func requireInteractions(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requestConfig(r).interactionsEnabled() {
+			http.NotFound(w, r)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// verifyInteraction checks the Ed25519 signature Discord puts on every
+// interaction request against the public key of cfg.
+func verifyInteraction(cfg *Config, r *http.Request, body []byte) bool {
+	signature, err := hex.DecodeString(r.Header.Get("X-Signature-Ed25519"))
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return false
+	}
+	timestamp := r.Header.Get("X-Signature-Timestamp")
+	if timestamp == "" {
+		return false
+	}
+	return ed25519.Verify(cfg.interactionKey, append([]byte(timestamp), body...), signature)
+}
+
+func handleInteraction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "interactions must be POSTed by Discord", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "failed to read request body", http.StatusBadRequest)
+		return
+	}
+	cfg := requestConfig(r)
+	// Discord checks that requests with bad signatures are rejected.
+	if !verifyInteraction(cfg, r, body) {
+		slog.Warn("Rejected Discord interaction with invalid signature", "remote", r.RemoteAddr)
+		http.Error(w, "invalid request signature", http.StatusUnauthorized)
+		return
+	}
+
+	var interaction discordInteraction
+	if err := json.Unmarshal(body, &interaction); err != nil {
+		http.Error(w, "malformed interaction", http.StatusBadRequest)
+		return
+	}
+
+	var response interactionResponse
+	switch {
+	case interaction.Type == interactionPing:
+		response = interactionResponse{Type: responsePong}
+	case interaction.Type == interactionMessageComponent && strings.HasPrefix(interaction.Data.CustomID, ackCustomIDPrefix):
+		response = ackButtonClicked(&interaction)
+	case interaction.Type == interactionMessageComponent && strings.HasPrefix(interaction.Data.CustomID, silenceCustomIDPrefix):
+		response = silenceButtonClicked(cfg, &interaction)
+	case interaction.Type == interactionModalSubmit && strings.HasPrefix(interaction.Data.CustomID, silenceCustomIDPrefix):
+		response = silenceModalSubmitted(r.Context(), cfg, &interaction)
+	case interaction.Type == interactionApplicationCommand && interaction.Data.Name == "silence":
+		response = silenceCommand(r.Context(), cfg, &interaction)
+	default:
+		slog.Warn("Unsupported Discord interaction", "type", interaction.Type, "custom_id", interaction.Data.CustomID, "command", interaction.Data.Name)
+		response = ephemeralReply("This action is not supported.")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// ephemeralReply answers an interaction with a message only its user sees.
+func ephemeralReply(text string) interactionResponse {
+	return interactionResponse{
+		Type: responseChannelMessageWithSource,
+		Data: interactionMessage{Content: text, Flags: messageFlagEphemeral, AllowedMentions: mentionSet{}.allowed()},
+	}
+}
+
+// silenceDenied answers a user without an allowed role.
+func silenceDenied(interaction *discordInteraction) interactionResponse {
+	slog.Warn("Rejected silence from Discord user without an allowed role", "user", interaction.user().Username)
+	return ephemeralReply("You are not allowed to create silences.")
+}
+
+// silenceButtonClicked asks for the duration and comment of the silence.
+func silenceButtonClicked(cfg *Config, interaction *discordInteraction) interactionResponse {
+	if !interaction.maySilence(cfg) {
+		return silenceDenied(interaction)
+	}
+	fingerprint, _ := parseCustomID(interaction.Data.CustomID, silenceCustomIDPrefix)
+	if _, ok := firingAlerts.get(fingerprint); !ok {
+		return ephemeralReply("This alert is no longer firing.")
+	}
+	return interactionResponse{
+		Type: responseModal,
+		Data: interactionModal{
+			CustomID: interaction.Data.CustomID,
+			Title:    "Silence alert",
+			Components: []DiscordComponent{
+				{Type: componentTypeActionRow, Components: []DiscordComponent{{
+					Type:     componentTypeTextInput,
+					CustomID: "duration",
+					Style:    textInputShort,
+					Label:    "Duration (e.g. 30m, 2h, 1d)",
+					Value:    model.Duration(cfg.Discord.Interactions.SilenceDuration).String(),
+				}}},
+				{Type: componentTypeActionRow, Components: []DiscordComponent{{
+					Type:     componentTypeTextInput,
+					CustomID: "comment",
+					Style:    textInputParagraph,
+					Label:    "Comment",
+					Value:    "Silenced from Discord",
+				}}},
+			},
+		},
+	}
+}
+
+// silenceModalSubmitted silences the alert of a submitted Silence modal and
+// replaces the Silence button with who silenced it.
+func silenceModalSubmitted(ctx context.Context, cfg *Config, interaction *discordInteraction) interactionResponse {
+	if !interaction.maySilence(cfg) {
+		return silenceDenied(interaction)
+	}
+	fingerprint, _ := parseCustomID(interaction.Data.CustomID, silenceCustomIDPrefix)
+	alert, ok := firingAlerts.get(fingerprint)
+	if !ok {
+		return ephemeralReply("This alert is no longer firing.")
+	}
+	baseURL := silenceAPIURL(cfg, alert.ExternalURL)
+	if baseURL == "" {
+		return ephemeralReply("Set alertmanager.url to create silences.")
+	}
+	duration, err := model.ParseDuration(interaction.input("duration"))
+	if err != nil || duration <= 0 {
+		return ephemeralReply(fmt.Sprintf("Invalid duration %q.", interaction.input("duration")))
+	}
+
+	user := interaction.user()
+	matchers := exactMatchers(alert.Labels)
+	id, err := silenceFor(ctx, cfg, baseURL, user, toSilenceMatchers(matchers), time.Duration(duration), interaction.input("comment"))
+	if err != nil {
+		return ephemeralReply("Failed to create the silence: " + err.Error())
+	}
+	slog.Info("Silenced alert from Discord", "fingerprint", fingerprint, "user", user.Username, "duration", duration, "silence_id", id)
+	firingAlerts.silence(matchers, time.Now().Add(time.Duration(duration)))
+
+	if interaction.Message == nil {
+		return ephemeralReply(fmt.Sprintf("Silenced for %s.", duration))
+	}
+	label, _ := truncateText(fmt.Sprintf("Silenced by %s for %s", user.name(), duration), discordMaxButtonLabel)
+	return interactionResponse{
+		Type: responseUpdateMessage,
+		Data: interactionMessage{Components: replaceButton(interaction.Message.Components, interaction.Data.CustomID, label)},
+	}
+}
+
+// ackButtonClicked records who acknowledged the alert and shows it in the
+// alert's embed and on the button.
+func ackButtonClicked(interaction *discordInteraction) interactionResponse {
+	fingerprint, index := parseCustomID(interaction.Data.CustomID, ackCustomIDPrefix)
+	user := interaction.user()
+	ack, isNew, ok := firingAlerts.acknowledge(fingerprint, user)
+	if !ok {
+		return ephemeralReply("This alert is no longer firing.")
+	}
+	if !isNew {
+		return ephemeralReply(fmt.Sprintf("Already acknowledged by %s <t:%d:R>.", ack.By, ack.At.Unix()))
+	}
+	slog.Info("Acknowledged alert from Discord", "fingerprint", fingerprint, "user", user.Username)
+
+	if interaction.Message == nil {
+		return ephemeralReply("Acknowledged.")
+	}
+	label, _ := truncateText("Acked by "+user.name(), discordMaxButtonLabel)
+	update := interactionMessage{Components: replaceButton(interaction.Message.Components, interaction.Data.CustomID, label)}
+	if embeds, ok := addAckField(interaction.Message.Embeds, index, ack); ok {
+		update.Embeds = embeds
+		sentMessages.replaceEmbeds(interaction.Message.ID, embeds)
+	}
+	return interactionResponse{Type: responseUpdateMessage, Data: update}
+}
+
+// addAckField returns a copy of embeds with an "Acknowledged" field added
+// to the index-th titled embed, which starts the embeds of the alert. It
+// reports false when that embed is not part of the message or is full.
+func addAckField(embeds DiscordEmbeds, index int, ack alertAck) (DiscordEmbeds, bool) {
+	field := DiscordEmbedField{Name: "Acknowledged", Value: fmt.Sprintf("Acked by %s <t:%d:R>", ack.By, ack.At.Unix()), Inline: true}
+	total := 0
+	for _, embed := range embeds {
+		total += embedLength(embed)
+	}
+	for i, titled := 0, -1; i < len(embeds) && index >= 0; i++ {
+		if embeds[i].Title == "" {
+			continue
+		}
+		if titled++; titled != index {
+			continue
+		}
+		if len(embeds[i].Fields) >= discordMaxFields ||
+			total+embedLength(DiscordEmbed{Fields: DiscordEmbedFields{field}}) > discordMaxEmbedChars {
+			return nil, false
+		}
+		updated := append(DiscordEmbeds(nil), embeds...)
+		updated[i].Fields = append(append(DiscordEmbedFields(nil), embeds[i].Fields...), field)
+		return updated, true
+	}
+	return nil, false
+}
+
+// ackStatus is an acknowledged alert as served on acksPath.
+type ackStatus struct {
+	Fingerprint string    `json:"fingerprint"`
+	Labels      KV        `json:"labels"`
+	StartsAt    time.Time `json:"startsAt"`
+	AckedBy     string    `json:"ackedBy"`
+	AckedByID   string    `json:"ackedById,omitempty"`
+	AckedAt     time.Time `json:"ackedAt"`
+}
+
+// handleAcks lists the acknowledged firing alerts. With ?fingerprint= it
+// returns that alert, or 404 when it is not acknowledged.
+func handleAcks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	statuses := []ackStatus{}
+	for _, a := range firingAlerts.acknowledged() {
+		statuses = append(statuses, ackStatus{
+			Fingerprint: a.Fingerprint,
+			Labels:      a.Labels,
+			StartsAt:    a.StartsAt,
+			AckedBy:     a.Ack.By,
+			AckedByID:   a.Ack.UserID,
+			AckedAt:     a.Ack.At,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fingerprint := r.URL.Query().Get("fingerprint")
+	if fingerprint == "" {
+		json.NewEncoder(w).Encode(statuses)
+		return
+	}
+	for _, status := range statuses {
+		if status.Fingerprint == fingerprint {
+			json.NewEncoder(w).Encode(status)
+			return
+		}
+	}
+	http.Error(w, "alert is not acknowledged", http.StatusNotFound)
+}
+
+// silenceCommand handles /silence matchers:<matchers> [duration] [comment].
+func silenceCommand(ctx context.Context, cfg *Config, interaction *discordInteraction) interactionResponse {
+	if !interaction.maySilence(cfg) {
+		return silenceDenied(interaction)
+	}
+	baseURL := silenceAPIURL(cfg, "")
+	if baseURL == "" {
+		return ephemeralReply("Set alertmanager.url to create silences.")
+	}
+	matchers, err := parseLabelMatchers(splitMatcherList(interaction.option("matchers")))
+	if err != nil || len(matchers) == 0 {
+		return ephemeralReply(fmt.Sprintf("Invalid matchers %q; use e.g. alertname=\"HighLoad\", instance=~\"web.*\".", interaction.option("matchers")))
+	}
+	duration := model.Duration(cfg.Discord.Interactions.SilenceDuration)
+	if option := interaction.option("duration"); option != "" {
+		duration, err = model.ParseDuration(option)
+		if err != nil || duration <= 0 {
+			return ephemeralReply(fmt.Sprintf("Invalid duration %q.", option))
+		}
+	}
+	user := interaction.user()
+	id, err := silenceFor(ctx, cfg, baseURL, user, toSilenceMatchers(matchers), time.Duration(duration), interaction.option("comment"))
+	if err != nil {
+		return ephemeralReply("Failed to create the silence: " + err.Error())
+	}
+	var shown []string
+	for _, m := range matchers {
+		shown = append(shown, m.String())
+	}
+	slog.Info("Created silence from Discord", "matchers", strings.Join(shown, ","), "user", user.Username, "duration", duration, "silence_id", id)
+	firingAlerts.silence(matchers, time.Now().Add(time.Duration(duration)))
+	return interactionResponse{
+		Type: responseChannelMessageWithSource,
+		Data: interactionMessage{
+			Content:         fmt.Sprintf("🔕 %s silenced `{%s}` for %s (silence %s).", user.name(), strings.Join(shown, ", "), duration, id),
+			AllowedMentions: mentionSet{}.allowed(),
+		},
+	}
+}
+
+// silenceFor creates a silence on behalf of a Discord user, with the HTTP
+// client of cfg.
+func silenceFor(ctx context.Context, cfg *Config, baseURL string, user discordUser, matchers []silenceMatcher, duration time.Duration, comment string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, silenceTimeout)
+	defer cancel()
+
+	if comment == "" {
+		comment = "Silenced from Discord"
+	}
+	now := time.Now()
+	return createSilence(ctx, cfg.httpClient, baseURL, silence{
+		Matchers:  matchers,
+		StartsAt:  now,
+		EndsAt:    now.Add(duration),
+		CreatedBy: user.Username + " (Discord)",
+		Comment:   comment,
+	})
+}
+
+// replaceButton returns rows with the button customID turned into a
+// disabled button showing label.
+func replaceButton(rows []DiscordComponent, customID, label string) []DiscordComponent {
+	updated := make([]DiscordComponent, len(rows))
+	for i, row := range rows {
+		updated[i] = row
+		updated[i].Components = append([]DiscordComponent(nil), row.Components...)
+		for j, c := range updated[i].Components {
+			if c.CustomID == customID {
+				updated[i].Components[j].Label = label
+				updated[i].Components[j].Disabled = true
+			}
+		}
+	}
+	return updated
+}
+
+// splitMatcherList splits a comma-separated list of matchers, optionally
+// in braces like Alertmanager shows them. Commas inside quotes are kept.
+func splitMatcherList(s string) []string {
+	s = strings.TrimSpace(s)
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "{"), "}")
+
+	var matchers []string
+	var current strings.Builder
+	quoted, escaped := false, false
+	for _, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\' && quoted:
+			escaped = true
+		case r == '"':
+			quoted = !quoted
+		case r == ',' && !quoted:
+			matchers = append(matchers, current.String())
+			current.Reset()
+			continue
+		}
+		current.WriteRune(r)
+	}
+	matchers = append(matchers, current.String())
+
+	var nonEmpty []string
+	for _, m := range matchers {
+		if strings.TrimSpace(m) != "" {
+			nonEmpty = append(nonEmpty, m)
+		}
+	}
+	return nonEmpty
+}
+
+// registerSilenceCommand creates or updates the /silence slash command of
+// the application.
+func registerSilenceCommand() error {
+	cfg := config()
+	endpoint, err := discordAPIURL(cfg.Discord.WebhookURL, "/applications/"+cfg.Discord.Interactions.ApplicationID+"/commands")
+	if err != nil {
+		return err
+	}
+	stringOption := func(name, description string, required bool) map[string]interface{} {
+		return map[string]interface{}{"type": 3, "name": name, "description": description, "required": required}
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"name":        "silence",
+		"description": "Create an Alertmanager silence",
+		// Only members who can manage messages see the command until a
+		// server admin changes that under Integrations; it cannot be used
+		// in direct messages.
+		"default_member_permissions": strconv.Itoa(permissionManageMessages),
+		"dm_permission":              false,
+		"options": []interface{}{
+			stringOption("matchers", `Label matchers, e.g. alertname="HighLoad", instance=~"web.*"`, true),
+			stringOption("duration", "How long to silence, e.g. 2h or 1d", false),
+			stringOption("comment", "Why the alerts are silenced", false),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	header := http.Header{"Authorization": {"Bot " + cfg.Discord.BotToken}}
+	_, err = discordRequest(cfg, http.MethodPost, defaultWebhookName, endpoint, header, body)
+	return err
+}
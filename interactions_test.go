@@ -0,0 +1,214 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// withInteractionKey enables interactions with a new key pair and returns
+// its private key.
+func withInteractionKey(t *testing.T) (*Config, ed25519.PrivateKey) {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := defaultConfig()
+	cfg.Discord.Interactions.PublicKey = hex.EncodeToString(public)
+	cfg.interactionKey = public
+	withConfig(t, cfg)
+	return cfg, private
+}
+
+func signedInteraction(key ed25519.PrivateKey, timestamp, body string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, interactionsPath, strings.NewReader(body))
+	r.Header.Set("X-Signature-Timestamp", timestamp)
+	r.Header.Set("X-Signature-Ed25519", hex.EncodeToString(ed25519.Sign(key, []byte(timestamp+body))))
+	return r
+}
+
+func TestVerifyInteraction(t *testing.T) {
+	cfg, key := withInteractionKey(t)
+	_, otherKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const body = `{"type":1}`
+
+	for _, tc := range []struct {
+		name   string
+		modify func(r *http.Request) *http.Request
+		body   string
+		want   bool
+	}{
+		{"valid", func(r *http.Request) *http.Request { return r }, body, true},
+		{"other body", func(r *http.Request) *http.Request { return r }, `{"type":2}`, false},
+		{"other timestamp", func(r *http.Request) *http.Request {
+			r.Header.Set("X-Signature-Timestamp", "1700000001")
+			return r
+		}, body, false},
+		{"missing timestamp", func(r *http.Request) *http.Request {
+			r.Header.Del("X-Signature-Timestamp")
+			return r
+		}, body, false},
+		{"missing signature", func(r *http.Request) *http.Request {
+			r.Header.Del("X-Signature-Ed25519")
+			return r
+		}, body, false},
+		{"signature not hex", func(r *http.Request) *http.Request {
+			r.Header.Set("X-Signature-Ed25519", strings.Repeat("zz", ed25519.SignatureSize))
+			return r
+		}, body, false},
+		{"short signature", func(r *http.Request) *http.Request {
+			r.Header.Set("X-Signature-Ed25519", r.Header.Get("X-Signature-Ed25519")[:20])
+			return r
+		}, body, false},
+		{"signed with another key", func(r *http.Request) *http.Request {
+			return signedInteraction(otherKey, "1700000000", body)
+		}, body, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := tc.modify(signedInteraction(key, "1700000000", body))
+			if got := verifyInteraction(cfg, r, []byte(tc.body)); got != tc.want {
+				t.Errorf("verifyInteraction = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestHandleInteractionChecksSignature(t *testing.T) {
+	_, key := withInteractionKey(t)
+	const ping = `{"type":1}`
+
+	w := httptest.NewRecorder()
+	handleInteraction(w, signedInteraction(key, "1700000000", ping))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"type":1`) {
+		t.Errorf("signed ping: %d %s, want 200 with a pong", w.Code, w.Body)
+	}
+
+	r := signedInteraction(key, "1700000000", ping)
+	r.Header.Set("X-Signature-Timestamp", "1700000001")
+	w = httptest.NewRecorder()
+	handleInteraction(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("ping with a bad signature: %d, want 401", w.Code)
+	}
+}
+
+func TestParseCustomID(t *testing.T) {
+	for _, tc := range []struct {
+		customID    string
+		fingerprint string
+		index       int
+	}{
+		{"silence:abc123:2", "abc123", 2},
+		{"silence:abc123:0", "abc123", 0},
+		{"silence:abc123", "abc123", -1},
+		{"silence:abc123:", "abc123", -1},
+		{"silence:abc123:x", "abc123", -1},
+		{"silence:abc123:2:3", "abc123", -1},
+		{"silence:", "", -1},
+		{"silence::4", "", 4},
+		{"abc123:1", "abc123", 1},
+	} {
+		fingerprint, index := parseCustomID(tc.customID, silenceCustomIDPrefix)
+		if fingerprint != tc.fingerprint || index != tc.index {
+			t.Errorf("parseCustomID(%q) = %q, %d; want %q, %d", tc.customID, fingerprint, index, tc.fingerprint, tc.index)
+		}
+	}
+}
+
+func TestSplitMatcherList(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		want []string
+	}{
+		{`alertname="Disk"`, []string{`alertname="Disk"`}},
+		{`{alertname="Disk", job="node"}`, []string{`alertname="Disk"`, ` job="node"`}},
+		{` { a="1" } `, []string{` a="1" `}},
+		{`summary="a, b",job="x"`, []string{`summary="a, b"`, `job="x"`}},
+		{`summary="say \"hi, there\"",job="x"`, []string{`summary="say \"hi, there\""`, `job="x"`}},
+		{`a="1",,b="2",`, []string{`a="1"`, `b="2"`}},
+		{`summary="unterminated, x`, []string{`summary="unterminated, x`}},
+		{``, nil},
+		{`{}`, nil},
+		{` , `, nil},
+	} {
+		if got := splitMatcherList(tc.s); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("splitMatcherList(%q) = %q, want %q", tc.s, got, tc.want)
+		}
+	}
+}
+
+func TestSilenceRequiresAllowedRole(t *testing.T) {
+	var created atomic.Int32
+	alertmanager := fakeAlertmanager(t, func(w http.ResponseWriter, r *http.Request) {
+		created.Add(1)
+		w.Write([]byte(`{"silenceID":"s1"}`))
+	})
+	cfg, key := withInteractionKey(t)
+	cfg.Discord.Interactions.AllowedRoles = []string{"111"}
+	cfg.Alertmanager.URL = alertmanager.URL
+	cfg.httpClient = http.DefaultClient
+	previous := firingAlerts
+	firingAlerts, _ = openAlertStore(StateConfig{})
+	t.Cleanup(func() { firingAlerts = previous })
+
+	const command = `"type":2,"data":{"name":"silence","options":[{"name":"matchers","value":"alertname=\"RoleTest\""}]}`
+	for _, tc := range []struct {
+		name    string
+		body    string
+		allowed bool
+	}{
+		{"button without the role", `{"type":3,"data":{"custom_id":"silence:abc:0"},"member":{"user":{"username":"bob"},"roles":["222"]}}`, false},
+		{"modal without the role", `{"type":5,"data":{"custom_id":"silence:abc:0"},"member":{"user":{"username":"bob"},"roles":["222"]}}`, false},
+		{"command without the role", `{` + command + `,"member":{"user":{"username":"bob"},"roles":["222"]}}`, false},
+		{"command in a direct message", `{` + command + `,"user":{"username":"bob"}}`, false},
+		{"command with the role", `{` + command + `,"member":{"user":{"username":"alice"},"roles":["222","111"]}}`, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			before := created.Load()
+			w := httptest.NewRecorder()
+			handleInteraction(w, signedInteraction(key, "1700000000", tc.body))
+
+			denied := strings.Contains(w.Body.String(), "not allowed")
+			if denied == tc.allowed {
+				t.Errorf("response %s, want allowed = %v", w.Body, tc.allowed)
+			}
+			if got := created.Load() - before; tc.allowed != (got == 1) {
+				t.Errorf("created %d silences", got)
+			}
+		})
+	}
+}
+
+func TestRegisterSilenceCommandRestrictsMembers(t *testing.T) {
+	var command map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&command)
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+	cfg, _ := withInteractionKey(t)
+	cfg.Discord.WebhookURL = srv.URL + "/api/webhooks/1/token"
+	cfg.Discord.Interactions.ApplicationID = "42"
+	cfg.Discord.BotToken = "bot"
+	cfg.httpClient = http.DefaultClient
+
+	if err := registerSilenceCommand(); err != nil {
+		t.Fatalf("registerSilenceCommand: %v", err)
+	}
+	if got := command["default_member_permissions"]; got != "8192" {
+		t.Errorf("default_member_permissions = %v, want MANAGE_MESSAGES", got)
+	}
+	if got := command["dm_permission"]; got != false {
+		t.Errorf("dm_permission = %v, want false", got)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// sourceFilter decides which client addresses may call the webhook.
+type sourceFilter struct {
+	allowed []netip.Prefix
+	denied  []netip.Prefix
+	// proxies are trusted to report the client in X-Forwarded-For.
+	proxies []netip.Prefix
+}
+
+// parsePrefixes parses CIDRs such as 10.0.0.0/8; plain addresses stand for
+// themselves.
+func parsePrefixes(where string, list []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %q is not an IP address or CIDR", where, s)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %q is not an IP address or CIDR", where, s)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+func newSourceFilter(cfg SecurityConfig) (*sourceFilter, error) {
+	var f sourceFilter
+	var err error
+	if f.allowed, err = parsePrefixes("security.allowed_ips", cfg.AllowedIPs); err != nil {
+		return nil, err
+	}
+	if f.denied, err = parsePrefixes("security.denied_ips", cfg.DeniedIPs); err != nil {
+		return nil, err
+	}
+	if f.proxies, err = parsePrefixes("security.trusted_proxies", cfg.TrustedProxies); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientAddr returns the address of the client of r. Requests from trusted
+// proxies are attributed to the last address in X-Forwarded-For that is not
+// a trusted proxy itself.
+func (f *sourceFilter) clientAddr(r *http.Request) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	addr = addr.Unmap()
+	if !containsAddr(f.proxies, addr) {
+		return addr, true
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			// A garbled entry cannot be trusted; neither can anything
+			// before it.
+			return netip.Addr{}, false
+		}
+		addr = hop.Unmap()
+		if !containsAddr(f.proxies, addr) {
+			return addr, true
+		}
+	}
+	return addr, true
+}
+
+// allows reports whether addr may call the webhook. Denied addresses are
+// rejected even when they are allowed too; without an allow list every
+// other address is allowed.
+func (f *sourceFilter) allows(addr netip.Addr) bool {
+	if containsAddr(f.denied, addr) {
+		return false
+	}
+	return len(f.allowed) == 0 || containsAddr(f.allowed, addr)
+}
+
+// restrictSources answers requests from clients that are not allowed with
+// 403 before anything else is done with them.
+func restrictSources(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f := requestConfig(r).sources
+		if len(f.allowed) == 0 && len(f.denied) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		addr, ok := f.clientAddr(r)
+		if !ok || !f.allows(addr) {
+			slog.Warn("Rejected request from disallowed address", "remote", r.RemoteAddr, "client", addr, "path", r.URL.Path)
+			forbiddenRequests.Inc()
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
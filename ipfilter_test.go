@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// withSourceFilter allows only 10.0.0.0/8, trusting 10.0.0.1 as a proxy.
+func withSourceFilter(t *testing.T) {
+	t.Helper()
+	cfg := defaultConfig()
+	cfg.Health.Enabled = true
+	cfg.Metrics.Enabled = true
+	cfg.Metrics.Endpoint = "/metrics"
+	cfg.auth = &inboundAuth{}
+	sources, err := newSourceFilter(SecurityConfig{
+		AllowedIPs:     []string{"10.0.0.0/8"},
+		DeniedIPs:      []string{"10.66.0.0/16"},
+		TrustedProxies: []string{"10.0.0.1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.sources = sources
+	withConfig(t, cfg)
+}
+
+func TestSourceFilterCoversAllEndpoints(t *testing.T) {
+	withSourceFilter(t)
+	mux := newServeMux()
+
+	for _, tc := range []struct {
+		method, path, remote string
+		want                 int
+	}{
+		{http.MethodGet, "/healthz", "10.1.2.3:1234", http.StatusOK},
+		{http.MethodGet, "/healthz", "192.0.2.1:1234", http.StatusForbidden},
+		{http.MethodGet, "/healthz", "10.66.0.5:1234", http.StatusForbidden},
+		{http.MethodGet, "/readyz", "192.0.2.1:1234", http.StatusForbidden},
+		{http.MethodGet, "/metrics", "10.1.2.3:1234", http.StatusOK},
+		{http.MethodGet, "/metrics", "192.0.2.1:1234", http.StatusForbidden},
+		{http.MethodPost, "/", "192.0.2.1:1234", http.StatusForbidden},
+		{http.MethodPost, reloadPath, "192.0.2.1:1234", http.StatusForbidden},
+		{http.MethodGet, acksPath, "192.0.2.1:1234", http.StatusForbidden},
+		// Interactions are disabled, but not hidden behind the filter.
+		{http.MethodPost, interactionsPath, "192.0.2.1:1234", http.StatusNotFound},
+	} {
+		r := httptest.NewRequest(tc.method, tc.path, nil)
+		r.RemoteAddr = tc.remote
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		if w.Code != tc.want {
+			t.Errorf("%s %s from %s: status %d, want %d", tc.method, tc.path, tc.remote, w.Code, tc.want)
+		}
+	}
+}
+
+func TestSourceFilterForwardedFor(t *testing.T) {
+	withSourceFilter(t)
+	handler := restrictSources(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, tc := range []struct {
+		remote, forwardedFor string
+		want                 int
+	}{
+		{"10.0.0.1:1234", "10.1.2.3", http.StatusOK},
+		{"10.0.0.1:1234", "192.0.2.1", http.StatusForbidden},
+		{"10.0.0.1:1234", "192.0.2.1, 10.1.2.3", http.StatusOK},
+		{"10.0.0.1:1234", "10.1.2.3, garbage", http.StatusForbidden},
+		// Only trusted proxies may name the client.
+		{"10.1.2.3:1234", "192.0.2.1", http.StatusOK},
+		{"192.0.2.1:1234", "10.1.2.3", http.StatusForbidden},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+		r.RemoteAddr = tc.remote
+		r.Header.Set("X-Forwarded-For", tc.forwardedFor)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tc.want {
+			t.Errorf("from %s for %q: status %d, want %d", tc.remote, tc.forwardedFor, w.Code, tc.want)
+		}
+	}
+}
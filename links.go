@@ -0,0 +1,180 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Discord limits for message components.
+const (
+	discordMaxActionRows    = 5
+	discordMaxRowButtons    = 5
+	discordMaxButtonLabel   = 80
+	discordMaxButtonURLSize = 512
+)
+
+const (
+	componentTypeActionRow = 1
+	componentTypeButton    = 2
+
+	buttonStylePrimary   = 1
+	buttonStyleSecondary = 2
+	buttonStyleLink      = 5
+)
+
+// DiscordComponent is a message component: an action row, a button, or a
+// text input of a modal.
+type DiscordComponent struct {
+	Type       int                `json:"type"`
+	Style      int                `json:"style,omitempty"`
+	Label      string             `json:"label,omitempty"`
+	URL        string             `json:"url,omitempty"`
+	CustomID   string             `json:"custom_id,omitempty"`
+	Value      string             `json:"value,omitempty"`
+	Disabled   bool               `json:"disabled,omitempty"`
+	Components []DiscordComponent `json:"components,omitempty"`
+}
+
+// Annotations that may hold the runbook and dashboard URLs of an alert, in
+// order of preference.
+var (
+	runbookAnnotations   = []string{"runbook_url", "runbook"}
+	dashboardAnnotations = []string{"dashboard_url", "grafana_url", "grafana_dashboard_url", "grafana_dashboard", "grafana"}
+)
+
+// alertLink is a link shown with an alert. Links with a customID are
+// buttons of the given style handled by the interactions endpoint instead.
+type alertLink struct {
+	name     string
+	url      string
+	customID string
+	style    int
+}
+
+// linkURL returns s if it is an absolute http or https URL, which is all
+// Discord accepts for links, and "" otherwise.
+func linkURL(s string) string {
+	s = strings.TrimSpace(s)
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ""
+	}
+	return s
+}
+
+// alertLinks returns the Silence, Runbook and Dashboard links of alert on
+// the named webhook. Resolved alerts get no Silence link. With interactions
+// enabled, application webhooks get Acknowledge and Silence buttons that
+// act on the alert from Discord instead.
+func alertLinks(cfg *Config, alertManagerData *AlertManagerData, webhook string, alert *AlertManagerAlert) []alertLink {
+	var links []alertLink
+	if alert.Status == "firing" && cfg.interactionsEnabled() && applicationWebhook(cfg, webhook) && alert.Fingerprint != "" {
+		links = append(links,
+			alertLink{name: "Acknowledge", customID: ackCustomIDPrefix + alert.Fingerprint, style: buttonStylePrimary},
+			alertLink{name: "Silence", customID: silenceCustomIDPrefix + alert.Fingerprint, style: buttonStyleSecondary},
+		)
+	} else if alert.Status == "firing" {
+		if silence := silenceURL(cfg, alertManagerData, alert); silence != "" {
+			links = append(links, alertLink{name: "Silence", url: silence})
+		}
+	}
+	if runbook := annotationURL(alert, runbookAnnotations); runbook != "" {
+		links = append(links, alertLink{name: "Runbook", url: runbook})
+	}
+	if dashboard := annotationURL(alert, dashboardAnnotations); dashboard != "" {
+		links = append(links, alertLink{name: "Dashboard", url: dashboard})
+	}
+	return links
+}
+
+// annotationURL returns the first valid URL among the named annotations.
+func annotationURL(alert *AlertManagerAlert, names []string) string {
+	for _, name := range names {
+		if u := linkURL(alert.Annotations[name]); u != "" {
+			return u
+		}
+	}
+	return ""
+}
+
+// alertmanagerURL is the base URL of the Alertmanager UI: alertmanager.url
+// if set, and the externalURL of the notification otherwise.
+func alertmanagerURL(cfg *Config, alertManagerData *AlertManagerData) string {
+	if cfg.Alertmanager.URL != "" {
+		return linkURL(cfg.Alertmanager.URL)
+	}
+	return linkURL(alertManagerData.ExternalURL)
+}
+
+// silenceURL links to Alertmanager's new silence form, pre-filled with
+// matchers for all labels of alert.
+func silenceURL(cfg *Config, alertManagerData *AlertManagerData, alert *AlertManagerAlert) string {
+	base := alertmanagerURL(cfg, alertManagerData)
+	if base == "" || len(alert.Labels) == 0 {
+		return ""
+	}
+	var matchers []string
+	for _, pair := range alert.Labels.SortedPairs() {
+		matchers = append(matchers, pair.Name+"="+strconv.Quote(pair.Value))
+	}
+	filter := "{" + strings.Join(matchers, ", ") + "}"
+	// The filter lives in the URL fragment, where "+" is not a space.
+	return strings.TrimSuffix(base, "/") + "/#/silences/new?filter=" + strings.ReplaceAll(url.QueryEscape(filter), "+", "%20")
+}
+
+// applicationWebhook reports whether the named webhook is owned by a Discord
+// application and can therefore send buttons.
+func applicationWebhook(cfg *Config, webhook string) bool {
+	return containsString(cfg.Discord.ApplicationWebhooks, webhook)
+}
+
+// splitAlertLinks returns the links of alert that are sent as buttons on
+// the named webhook and those shown in a field of its embed instead.
+func splitAlertLinks(cfg *Config, webhook string, links []alertLink) (buttons, fields []alertLink) {
+	if !applicationWebhook(cfg, webhook) {
+		return nil, links
+	}
+	for _, link := range links {
+		if len(link.url) > discordMaxButtonURLSize || len(buttons) >= discordMaxRowButtons {
+			fields = append(fields, link)
+			continue
+		}
+		buttons = append(buttons, link)
+	}
+	return buttons, fields
+}
+
+// addLinksField appends links to embed as a field of markdown links.
+func addLinksField(embed *DiscordEmbed, links []alertLink) {
+	if len(links) == 0 || len(embed.Fields) >= discordMaxFields {
+		return
+	}
+	var parts []string
+	for _, link := range links {
+		parts = append(parts, "["+link.name+"]("+link.url+")")
+	}
+	embed.Fields = append(embed.Fields, DiscordEmbedField{Name: "Links", Value: strings.Join(parts, " · ")})
+}
+
+// linkButtonRow builds an action row of link buttons for the alert whose
+// embeds start with the index-th titled embed of the message; the index is
+// appended to the custom_id of interactive buttons so the embed can be
+// found again. When a message holds several rows, title tells which alert
+// the buttons belong to.
+func linkButtonRow(links []alertLink, title string, index int) DiscordComponent {
+	row := DiscordComponent{Type: componentTypeActionRow}
+	for _, link := range links {
+		label := link.name
+		if title != "" {
+			label, _ = truncateText(link.name+": "+title, discordMaxButtonLabel)
+		}
+		button := DiscordComponent{Type: componentTypeButton, Style: buttonStyleLink, Label: label, URL: link.url}
+		if link.customID != "" {
+			customID := link.customID + ":" + strconv.Itoa(index)
+			button = DiscordComponent{Type: componentTypeButton, Style: link.style, Label: label, CustomID: customID}
+		}
+		row.Components = append(row.Components, button)
+	}
+	return row
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSilenceURL(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		configured  string
+		externalURL string
+		labels      KV
+		want        string
+	}{
+		{"external URL", "", "http://alertmanager:9093", KV{"alertname": "Disk"},
+			"http://alertmanager:9093/#/silences/new?filter=%7Balertname%3D%22Disk%22%7D"},
+		{"configured URL wins", "https://am.example.com/", "http://alertmanager:9093", KV{"alertname": "Disk"},
+			"https://am.example.com/#/silences/new?filter=%7Balertname%3D%22Disk%22%7D"},
+		{"path prefix", "https://example.com/alertmanager", "", KV{"alertname": "Disk"},
+			"https://example.com/alertmanager/#/silences/new?filter=%7Balertname%3D%22Disk%22%7D"},
+		{"labels are sorted and spaces escaped", "", "http://am", KV{"instance": "a:9100", "alertname": "Disk Full"},
+			"http://am/#/silences/new?filter=%7Balertname%3D%22Disk%20Full%22%2C%20instance%3D%22a%3A9100%22%7D"},
+		{"quotes and plus signs", "", "http://am", KV{"query": `a"b+c`},
+			"http://am/#/silences/new?filter=%7Bquery%3D%22a%5C%22b%2Bc%22%7D"},
+		{"no labels", "", "http://am", KV{}, ""},
+		{"no Alertmanager URL", "", "", KV{"alertname": "Disk"}, ""},
+		{"not an http URL", "", "alertmanager:9093", KV{"alertname": "Disk"}, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := defaultConfig()
+			cfg.Alertmanager.URL = tc.configured
+			got := silenceURL(cfg, &AlertManagerData{ExternalURL: tc.externalURL}, &AlertManagerAlert{Labels: tc.labels})
+			if got != tc.want {
+				t.Errorf("silenceURL = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAlertLinks(t *testing.T) {
+	annotations := KV{"runbook": "https://wiki/runbook", "grafana_url": "not a url", "dashboard_url": "https://grafana/d/1"}
+	for _, tc := range []struct {
+		name         string
+		interactions bool
+		webhook      string
+		status       string
+		want         []string
+	}{
+		{"firing", false, "default", "firing", []string{"Silence", "Runbook", "Dashboard"}},
+		{"resolved", false, "default", "resolved", []string{"Runbook", "Dashboard"}},
+		{"interactive buttons", true, "app", "firing", []string{"Acknowledge", "Silence", "Runbook", "Dashboard"}},
+		{"interactions on a channel webhook", true, "default", "firing", []string{"Silence", "Runbook", "Dashboard"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := defaultConfig()
+			cfg.Discord.ApplicationWebhooks = []string{"app"}
+			if tc.interactions {
+				cfg.Discord.Interactions.PublicKey = "key"
+			}
+			alert := &AlertManagerAlert{Status: tc.status, Fingerprint: "abc", Labels: KV{"alertname": "Disk"}, Annotations: annotations}
+			links := alertLinks(cfg, &AlertManagerData{ExternalURL: "http://am"}, tc.webhook, alert)
+
+			var names []string
+			for _, link := range links {
+				names = append(names, link.name)
+				if link.url == "" && link.customID == "" {
+					t.Errorf("%s link has neither a URL nor a custom_id", link.name)
+				}
+			}
+			if !reflect.DeepEqual(names, tc.want) {
+				t.Errorf("links = %v, want %v", names, tc.want)
+			}
+		})
+	}
+}
+
+func TestSplitAlertLinks(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Discord.ApplicationWebhooks = []string{"app"}
+	long := "https://example.com/" + strings.Repeat("a", discordMaxButtonURLSize)
+	links := []alertLink{
+		{name: "Silence", url: "https://am/1"},
+		{name: "Runbook", url: long},
+		{name: "A", url: "https://a"}, {name: "B", url: "https://b"}, {name: "C", url: "https://c"},
+		{name: "D", url: "https://d"}, {name: "E", url: "https://e"},
+	}
+
+	buttons, fields := splitAlertLinks(cfg, "default", links)
+	if len(buttons) != 0 || len(fields) != len(links) {
+		t.Errorf("channel webhook: %d buttons and %d field links, want all links in the field", len(buttons), len(fields))
+	}
+
+	buttons, fields = splitAlertLinks(cfg, "app", links)
+	var buttonNames, fieldNames []string
+	for _, link := range buttons {
+		buttonNames = append(buttonNames, link.name)
+	}
+	for _, link := range fields {
+		fieldNames = append(fieldNames, link.name)
+	}
+	if want := []string{"Silence", "A", "B", "C", "D"}; !reflect.DeepEqual(buttonNames, want) {
+		t.Errorf("buttons = %v, want %v", buttonNames, want)
+	}
+	if want := []string{"Runbook", "E"}; !reflect.DeepEqual(fieldNames, want) {
+		t.Errorf("field links = %v, want %v", fieldNames, want)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// parseLogLevel maps the logging.level values of the config file.
+func parseLogLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return slog.LevelInfo, fmt.Errorf("unknown log level %q", level)
+}
+
+// newLogger builds the logger described by cfg. Verbose mode lowers the
+// level to debug. The returned closer releases the log file, if any.
+func newLogger(cfg LoggingConfig, verbose bool) (*slog.Logger, io.Closer, error) {
+	level, err := parseLogLevel(cfg.Level)
+	if err != nil {
+		return nil, nil, err
+	}
+	if verbose && level > slog.LevelDebug {
+		level = slog.LevelDebug
+	}
+
+	var out io.Writer = os.Stderr
+	var closer io.Closer = io.NopCloser(nil)
+	if cfg.File != "" {
+		f, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+		if err != nil {
+			return nil, nil, fmt.Errorf("opening log file: %w", err)
+		}
+		out, closer = f, f
+	}
+
+	options := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch strings.ToLower(cfg.Format) {
+	case "json":
+		handler = slog.NewJSONHandler(out, options)
+	case "", "text":
+		handler = slog.NewTextHandler(out, options)
+	default:
+		closer.Close()
+		return nil, nil, fmt.Errorf("unknown log format %q", cfg.Format)
+	}
+	return slog.New(handler), closer, nil
+}
+
+// notificationLogger returns a logger carrying the fields that identify an
+// Alertmanager notification.
+func notificationLogger(alertManagerData *AlertManagerData) *slog.Logger {
+	return slog.With("receiver", alertManagerData.Receiver, "groupKey", alertManagerData.GroupKey)
+}
+
+// fatal logs msg at error level and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
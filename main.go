@@ -2,18 +2,23 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"regexp"
 	"sort"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
+	"unicode/utf8"
 )
 
 // Discord color values
@@ -90,10 +95,12 @@ type DiscordEmbedFooter struct {
 }
 
 type DiscordMessage struct {
-	Content   string        `json:"content"`
-	Username  string        `json:"username"`
-	AvatarURL string        `json:"avatar_url"`
-	Embeds    DiscordEmbeds `json:"embeds"`
+	Content         string                  `json:"content"`
+	Username        string                  `json:"username"`
+	AvatarURL       string                  `json:"avatar_url"`
+	Embeds          DiscordEmbeds           `json:"embeds"`
+	AllowedMentions *DiscordAllowedMentions `json:"allowed_mentions,omitempty"`
+	Components      []DiscordComponent      `json:"components,omitempty"`
 }
 
 type DiscordEmbeds []DiscordEmbed
@@ -117,213 +124,609 @@ type DiscordEmbedField struct {
 }
 
 const defaultListenAddress = "127.0.0.1:9099"
-const discordEmbedLimit = 2  // Giảm xuống 2 để tránh quá tải message
-const maxDescriptionLength = 200  // Giảm xuống 200 để tránh vượt quá Discord limit
-const maxFieldValueLength = 150   // Giảm xuống 150 để tránh vượt quá Discord limit
-const maxTitleLength = 150       // Giới hạn độ dài title
-const maxAlertsPerMessage = 1    // Chỉ 1 alert per message để tránh quá tải
 
 var (
 	webhookURL               = flag.String("webhook.url", os.Getenv("DISCORD_WEBHOOK"), "Discord WebHook URL.")
+	healthcheck              = flag.Bool("healthcheck", false, "Query /healthz of the running instance and exit non-zero if it is unhealthy.")
 	additionalWebhookURLFlag = flag.String("additionalWebhook.urls", os.Getenv("ADDITIONAL_DISCORD_WEBHOOKS"), "Additional Discord WebHook URLs.")
 	listenAddress            = flag.String("listen.address", os.Getenv("LISTEN_ADDRESS"), "Address:Port to listen on.")
 	username                 = flag.String("username", os.Getenv("DISCORD_USERNAME"), "Overrides the predefined username of the webhook.")
 	avatarURL                = flag.String("avatar.url", os.Getenv("DISCORD_AVATAR_URL"), "Overrides the predefined avatar of the webhook.")
 	verboseMode              = flag.String("verbose", os.Getenv("VERBOSE"), "Verbose mode")
-	additionalWebhookURLs    []string
+	configFile               = flag.String("config", os.Getenv("CONFIG_FILE"), "Path to the YAML configuration file.")
+	deliveryMode             = flag.String("delivery.mode", os.Getenv("DELIVERY_MODE"), "Delivery mode: sync or async.")
+	queueDirectory           = flag.String("queue.directory", os.Getenv("QUEUE_DIRECTORY"), "Directory for the persistent delivery queue.")
+	stateDirectory           = flag.String("state.directory", os.Getenv("STATE_DIRECTORY"), "Directory where the IDs of posted Discord messages and threads are kept.")
+
+	// deliveries is the persistent delivery queue, nil when disabled.
+	deliveries *deliveryQueue
+	// sentMessages remembers posted messages for edit_on_resolve.
+	sentMessages *messageStore
+	// threads maps Alertmanager groups to Discord threads.
+	threads *threadStore
+	// firingAlerts tracks the firing alerts for actions taken in Discord.
+	firingAlerts *alertStore
+
+	// currentConfig holds the merged file, flag and environment settings.
+	// It is replaced as a whole when the configuration is reloaded.
+	currentConfig atomic.Pointer[Config]
 )
 
+func init() {
+	currentConfig.Store(defaultConfig())
+}
+
+// config returns the configuration in effect.
+func config() *Config {
+	return currentConfig.Load()
+}
+
+type configContextKey struct{}
+
+// snapshotConfig hands every request the configuration in effect when it
+// arrived, so a reload does not change it halfway through the request.
+func snapshotConfig(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), configContextKey{}, config())
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requestConfig returns the configuration snapshot of r, or the one in
+// effect for requests that did not pass snapshotConfig.
+func requestConfig(r *http.Request) *Config {
+	if cfg, ok := r.Context().Value(configContextKey{}).(*Config); ok {
+		return cfg
+	}
+	return config()
+}
+
 func checkWebhookURL(webhookURL string) bool {
 	if webhookURL == "" {
-		log.Fatalf("Environment variable 'DISCORD_WEBHOOK' or CLI parameter 'webhook.url' not found.")
+		fatal("Environment variable 'DISCORD_WEBHOOK' or CLI parameter 'webhook.url' not found")
 		return false
 	}
-	_, err := url.Parse(webhookURL)
+	ok, err := validWebhookURL(webhookURL)
 	if err != nil {
-		log.Fatalf("The Discord WebHook URL doesn't seem to be a valid URL.")
+		fatal("The Discord WebHook URL doesn't seem to be a valid URL", "error", err)
 		return false
 	}
+	return ok
+}
 
+// validWebhookURL reports whether webhookURL looks like a Discord webhook,
+// warning when it does not. Only a URL that cannot be parsed is an error,
+// so a reloaded configuration can be rejected instead of exiting.
+func validWebhookURL(webhookURL string) (bool, error) {
+	if _, err := url.Parse(webhookURL); err != nil {
+		return false, err
+	}
 	re := regexp.MustCompile(`https://discord(?:app)?.com/api/webhooks/[0-9]{18,19}/[a-zA-Z0-9_-]+`)
 	if ok := re.Match([]byte(webhookURL)); !ok {
-		log.Printf("The Discord WebHook URL doesn't seem to be valid.")
-		return false
+		slog.Warn("The Discord WebHook URL doesn't seem to be valid")
+		return false, nil
 	}
-	return true
+	return true, nil
 }
 func checkDiscordUserName(discordUserName string) {
 	if discordUserName == "" {
-		log.Fatalf("Environment variable 'DISCORD_USERNAME' or CLI parameter 'username' not found.")
+		fatal("Environment variable 'DISCORD_USERNAME' or CLI parameter 'username' not found")
 	}
 	_, err := url.Parse(discordUserName)
 	if err != nil {
-		log.Fatalf("The Discord UserName doesn't seem to be a valid.")
+		fatal("The Discord UserName doesn't seem to be valid", "error", err)
 	}
 }
 
-// Truncate string if too long
-func truncateString(s string, maxLen int) string {
-	if len(s) <= maxLen {
-		return s
-	}
-	return s[:maxLen-3] + "..."
+// deliveryResult counts what happened to the messages rendered for one
+// Alertmanager notification.
+type deliveryResult struct {
+	Delivered int
+	Queued    int
+	Failed    int
 }
 
-func sendWebhook(alertManagerData *AlertManagerData) {
+func (r *deliveryResult) add(other deliveryResult) {
+	r.Delivered += other.Delivered
+	r.Queued += other.Queued
+	r.Failed += other.Failed
+}
+
+// allFailed reports whether nothing could be delivered or queued.
+func (r deliveryResult) allFailed() bool {
+	return r.Failed > 0 && r.Delivered == 0 && r.Queued == 0
+}
+
+// sendWebhook renders and sends the alerts of a notification with the
+// configuration cfg, taken once for the whole notification.
+func sendWebhook(cfg *Config, alertManagerData *AlertManagerData) deliveryResult {
+	var result deliveryResult
 
 	groupedAlerts := make(map[string]AlertManagerAlerts)
+	var statuses []string
 
+	logger := notificationLogger(alertManagerData)
 	for _, alert := range alertManagerData.Alerts {
-		groupedAlerts[alert.Status] = append(groupedAlerts[alert.Status], alert)
+		logger.Debug("Processing alert", "fingerprint", alert.Fingerprint, "alertname", alert.Labels[AlertNameLabel], "status", alert.Status)
+		if alert.Status == "resolved" && !cfg.Alerts.SendResolved {
+			continue
+		}
+		status := alert.Status
+		if !cfg.Alerts.GroupByStatus {
+			status = alertManagerData.Status
+		}
+		if _, ok := groupedAlerts[status]; !ok {
+			statuses = append(statuses, status)
+		}
+		groupedAlerts[status] = append(groupedAlerts[status], alert)
 	}
 
-	for status, alerts := range groupedAlerts {
-
-		color := findColor(status)
-
-		// Process each alert individually to avoid overloading messages
-		for indx, alert := range alerts {
-			embeds := DiscordEmbeds{}
-			
-			// Create title safely with Discord limits (256 chars)
-			alertTitle := getAlertTitle(&alert)
-			alertTitle = strings.TrimSpace(strings.ReplaceAll(alertTitle, "(instance )", ""))
-			alertTitle = strings.TrimSpace(strings.ReplaceAll(alertTitle, "(instance)", ""))
-			alertTitle = truncateString(alertTitle, 250)  // Discord limit 256, để margin
-			if alertTitle == "" || strings.TrimSpace(alertTitle) == "" {
-				alertTitle = "Alert Notification"
-			}
-			
-			embedAlertMessage := DiscordEmbed{
-				Title:  alertTitle,
-				Color:  color,
-				Fields: DiscordEmbedFields{},
-			}
+	// Pack alerts into as few messages as Discord's limits allow, unless
+	// every alert should get its own message.
+	alertsPerMessage := cfg.Alerts.MaxAlertsPerMessage
+	if cfg.Alerts.IndividualMessages {
+		alertsPerMessage = 1
+	}
 
-			// Add description safely within Discord limits
-			desc := ""
-			if alert.Annotations["summary"] != "" {
-				desc = strings.TrimSpace(alert.Annotations["summary"])
-			} else if alert.Annotations["description"] != "" {
-				desc = strings.TrimSpace(alert.Annotations["description"])
-			}
-			
-			// Clean up description
-			if desc != "" {
-				desc = strings.TrimSpace(strings.ReplaceAll(desc, "map[]", ""))
-				desc = strings.TrimSpace(strings.ReplaceAll(desc, "(instance )", ""))
-				desc = strings.TrimSpace(strings.ReplaceAll(desc, "(instance)", ""))
-				if strings.TrimSpace(desc) != "" {
-					desc = truncateString(desc, 1000)  // Giới hạn description
-					embedAlertMessage.Description = desc
+	for _, status := range statuses {
+		// Route each alert, keeping the order of first appearance per webhook
+		routedAlerts := make(map[string]AlertManagerAlerts)
+		var webhooks []string
+		for _, alert := range groupedAlerts[status] {
+			for _, webhook := range cfg.router.route(&alert) {
+				if _, ok := routedAlerts[webhook]; !ok {
+					webhooks = append(webhooks, webhook)
 				}
+				routedAlerts[webhook] = append(routedAlerts[webhook], alert)
 			}
+		}
 
-			// Add message field if meaningful and different from summary
-			if msg := strings.TrimSpace(alert.Annotations["message"]); msg != "" && 
-               msg != alert.Annotations["summary"] {
-                msg = strings.TrimSpace(strings.ReplaceAll(msg, "map[]", ""))
-                msg = strings.TrimSpace(strings.ReplaceAll(msg, "(instance )", ""))
-                if msg != "" {
-                    msg = truncateString(msg, 800)  // Discord field limit 1024
-                    embedAlertMessage.Fields = append(embedAlertMessage.Fields, DiscordEmbedField{
-                        Name:   "Message",
-                        Value:  msg,
-                        Inline: false,
-                    })
-                }
-            }
-
-            // Add description field if meaningful and different
-            if desc := strings.TrimSpace(alert.Annotations["description"]); desc != "" && 
-               desc != embedAlertMessage.Description {
-                desc = strings.TrimSpace(strings.ReplaceAll(desc, "map[]", ""))
-                desc = strings.TrimSpace(strings.ReplaceAll(desc, "(instance )", ""))
-                if desc != "" && len(desc) > 10 {
-                    desc = truncateString(desc, 800)  // Discord field limit 1024
-                    embedAlertMessage.Fields = append(embedAlertMessage.Fields, DiscordEmbedField{
-                        Name:   "Description",
-                        Value:  desc,
-                        Inline: false,
-                    })
-                }
-            }
-
-            // Add details field with labels (cleaned up)
-            if details := getFormattedLabels(alert.Labels); details != "" {
-                embedAlertMessage.Fields = append(embedAlertMessage.Fields, DiscordEmbedField{
-                    Name:   "Details",
-                    Value:  details,
-                    Inline: false,
-                })
-            }
-
-            // Add footer and timestamp
-            if *username != "" {
-                footer := DiscordEmbedFooter{Text: *username}
-                embedAlertMessage.Footer = &footer
-                currentTime := time.Now()
-                embedAlertMessage.Timestamp = &currentTime
-            }
-
-            // Only add embed if it has meaningful content
-            if len(strings.TrimSpace(embedAlertMessage.Title)) > 3 &&
-               (len(strings.TrimSpace(embedAlertMessage.Description)) > 3 || len(embedAlertMessage.Fields) > 0) {
-                embeds = append(embeds, embedAlertMessage)
-            }
-
-            // Send each alert individually to avoid overloading
-            if len(embeds) > 0 {
-                log.Printf("Sending individual alert to Discord (alert %d/%d)", indx+1, len(alerts))
-                postMessageToDiscord(alertManagerData, status, color, embeds)
-				
-				// Delay between messages to avoid rate limiting
-				time.Sleep(200 * time.Millisecond)
+		for _, webhook := range webhooks {
+			result.add(sendAlertsToWebhook(cfg, alertManagerData, status, webhook, routedAlerts[webhook], alertsPerMessage))
+		}
+	}
+	return result
+}
+
+// sendAlertsToWebhook posts alerts to the named webhook, packing up to
+// alertsPerMessage alerts into each message.
+func sendAlertsToWebhook(cfg *Config, alertManagerData *AlertManagerData, status string, webhook string, alerts AlertManagerAlerts, alertsPerMessage int) deliveryResult {
+	var pending []*delivery
+
+	var rendered []renderedAlert
+	for _, alert := range alerts {
+		if cfg.Alerts.EditOnResolve && alert.Status == "resolved" {
+			if edits := editResolvedAlert(cfg, alertManagerData, webhook, &alert); len(edits) > 0 {
+				pending = append(pending, edits...)
+				continue
 			}
 		}
+		embedAlertMessage := buildAlertEmbed(cfg, alertManagerData, &alert, findColor(alert.Status))
+		buttons, fieldLinks := splitAlertLinks(cfg, webhook, alertLinks(cfg, alertManagerData, webhook, &alert))
+		addLinksField(&embedAlertMessage, fieldLinks)
+
+		// Only add embed if it has meaningful content
+		if len(strings.TrimSpace(embedAlertMessage.Title)) <= 3 ||
+			(len(strings.TrimSpace(embedAlertMessage.Description)) <= 3 && len(embedAlertMessage.Fields) == 0) {
+			continue
+		}
+		r := renderedAlert{
+			embeds:  DiscordEmbeds{embedAlertMessage},
+			content: cfg.templates.renderContent(alertManagerData, &alert),
+			buttons: buttons,
+		}
+		if cfg.Alerts.EditOnResolve && alert.Status == "firing" {
+			r.fingerprint = alert.Fingerprint
+		}
+		r.mentions = mentionsFor(cfg, webhook, &alert)
+		rendered = append(rendered, r)
 	}
+
+	sent := 0
+	for _, batch := range packAlerts(rendered, alertsPerMessage, cfg.Discord.Formatting.MaxEmbeds) {
+		sent += len(batch.alerts)
+		notificationLogger(alertManagerData).Info("Sending alerts to Discord",
+			"webhook", webhook, "status", status, "alerts", len(batch.alerts), "progress", fmt.Sprintf("%d/%d", sent, len(rendered)))
+		message, fingerprints := batch.message()
+		pending = append(pending, &delivery{WebhookName: webhook, Message: message, Fingerprints: fingerprints})
+	}
+
+	var result deliveryResult
+	for i, d := range pending {
+		if cfg.Discord.Threads.Mode != "" && alertManagerData.GroupKey != "" {
+			d.GroupKey = alertManagerData.GroupKey
+			d.ThreadName = threadName(alertManagerData)
+			// Once the whole group has resolved, its next incident starts
+			// a new thread.
+			d.CloseThread = alertManagerData.Status == "resolved" && i == len(pending)-1
+		}
+		result.add(postMessageToDiscord(cfg, alertManagerData, d))
+	}
+	return result
 }
 
-func postMessageToDiscord(alertManagerData *AlertManagerData, status string, color int, embeds DiscordEmbeds) {
-	discordMessage := DiscordMessage{}
-	addOverrideFields(&discordMessage)
-	
-	// Chỉ gửi embeds của individual alerts, không gửi header
-	discordMessage.Embeds = embeds
+// editResolvedAlert returns deliveries that turn the messages that announced
+// alert green and add how long it fired. It returns none when no such
+// message is known, in which case a new resolved message is posted instead.
+func editResolvedAlert(cfg *Config, alertManagerData *AlertManagerData, webhook string, alert *AlertManagerAlert) []*delivery {
+	embed := buildAlertEmbed(cfg, alertManagerData, alert, ColorGreen)
+	if len(embed.Fields) < 25 {
+		embed.Fields = append(embed.Fields, DiscordEmbedField{Name: "Resolved after", Value: resolvedAfter(alert), Inline: true})
+	}
+	// Buttons of the firing message stay in place when it is edited.
+	if !applicationWebhook(cfg, webhook) {
+		addLinksField(&embed, alertLinks(cfg, alertManagerData, webhook, alert))
+	}
+
+	var edits []*delivery
+	for _, m := range sentMessages.resolve(webhook, alert.Fingerprint, embed) {
+		notificationLogger(alertManagerData).Info("Editing Discord message of resolved alert",
+			"webhook", webhook, "fingerprint", alert.Fingerprint, "message_id", m.ID)
+		edits = append(edits, &delivery{
+			WebhookName: webhook,
+			Message:     DiscordMessage{Content: m.Content, Embeds: m.Embeds},
+			MessageID:   m.ID,
+			ThreadID:    m.ThreadID,
+		})
+	}
+	return edits
+}
+
+// resolvedAfter formats how long alert was firing.
+func resolvedAfter(alert *AlertManagerAlert) string {
+	endsAt := alert.EndsAt
+	if endsAt.IsZero() || endsAt.Before(alert.StartsAt) {
+		endsAt = time.Now()
+	}
+	duration, _ := humanizeDuration(endsAt.Sub(alert.StartsAt).Round(time.Second))
+	return duration
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// buildAlertEmbed renders a single alert into an embed using the
+// configured formatting limits and templates.
+func buildAlertEmbed(cfg *Config, alertManagerData *AlertManagerData, alert *AlertManagerAlert, color int) DiscordEmbed {
+	embed := buildDefaultAlertEmbed(cfg, alert, color)
+	cfg.templates.apply(&embed, alertManagerData, alert, cfg.Discord.Formatting)
+	return embed
+}
+
+// buildDefaultAlertEmbed is the built-in embed layout.
+func buildDefaultAlertEmbed(cfg *Config, alert *AlertManagerAlert, color int) DiscordEmbed {
+	formatting := cfg.Discord.Formatting
+
+	// Create title safely with Discord limits (256 chars)
+	alertTitle := getAlertTitle(alert)
+	alertTitle = strings.TrimSpace(strings.ReplaceAll(alertTitle, "(instance )", ""))
+	alertTitle = strings.TrimSpace(strings.ReplaceAll(alertTitle, "(instance)", ""))
+	alertTitle = truncateString(alertTitle, formatting.MaxTitleLength)
+	if alertTitle == "" || strings.TrimSpace(alertTitle) == "" {
+		alertTitle = "Alert Notification"
+	}
+
+	embedAlertMessage := DiscordEmbed{
+		Title:  alertTitle,
+		URL:    linkURL(alert.GeneratorURL),
+		Color:  color,
+		Fields: DiscordEmbedFields{},
+	}
+
+	// Add description safely within Discord limits
+	desc := ""
+	if alert.Annotations["summary"] != "" {
+		desc = strings.TrimSpace(alert.Annotations["summary"])
+	} else if alert.Annotations["description"] != "" {
+		desc = strings.TrimSpace(alert.Annotations["description"])
+	}
+
+	// Clean up description
+	if desc != "" {
+		desc = strings.TrimSpace(strings.ReplaceAll(desc, "map[]", ""))
+		desc = strings.TrimSpace(strings.ReplaceAll(desc, "(instance )", ""))
+		desc = strings.TrimSpace(strings.ReplaceAll(desc, "(instance)", ""))
+		if strings.TrimSpace(desc) != "" {
+			desc = truncateString(desc, formatting.MaxDescriptionLength)
+			embedAlertMessage.Description = desc
+		}
+	}
+
+	// Add message field if meaningful and different from summary
+	if msg := strings.TrimSpace(alert.Annotations["message"]); msg != "" &&
+		msg != alert.Annotations["summary"] {
+		msg = strings.TrimSpace(strings.ReplaceAll(msg, "map[]", ""))
+		msg = strings.TrimSpace(strings.ReplaceAll(msg, "(instance )", ""))
+		if msg != "" {
+			msg = truncateString(msg, formatting.MaxFieldValueLength)
+			embedAlertMessage.Fields = append(embedAlertMessage.Fields, DiscordEmbedField{
+				Name:   "Message",
+				Value:  msg,
+				Inline: false,
+			})
+		}
+	}
+
+	// Add description field if meaningful and different
+	if desc := strings.TrimSpace(alert.Annotations["description"]); desc != "" &&
+		desc != embedAlertMessage.Description {
+		desc = strings.TrimSpace(strings.ReplaceAll(desc, "map[]", ""))
+		desc = strings.TrimSpace(strings.ReplaceAll(desc, "(instance )", ""))
+		if desc != "" && len(desc) > 10 {
+			desc = truncateString(desc, formatting.MaxFieldValueLength)
+			embedAlertMessage.Fields = append(embedAlertMessage.Fields, DiscordEmbedField{
+				Name:   "Description",
+				Value:  desc,
+				Inline: false,
+			})
+		}
+	}
+
+	// Add details field with labels (cleaned up)
+	if details := getFormattedLabels(alert.Labels, formatting.MaxLabels); details != "" {
+		embedAlertMessage.Fields = append(embedAlertMessage.Fields, DiscordEmbedField{
+			Name:   "Details",
+			Value:  details,
+			Inline: false,
+		})
+	}
+
+	// Add footer and timestamp
+	if cfg.Discord.Username != "" {
+		footer := DiscordEmbedFooter{Text: cfg.Discord.Username}
+		embedAlertMessage.Footer = &footer
+		currentTime := time.Now()
+		embedAlertMessage.Timestamp = &currentTime
+	}
+
+	return embedAlertMessage
+}
+
+// postMessageToDiscord sends the message of d to the webhook named by
+// d.WebhookName, either directly or through the delivery queue depending on
+// the delivery mode. Messages over Discord's limits are split first.
+func postMessageToDiscord(cfg *Config, alertManagerData *AlertManagerData, d *delivery) deliveryResult {
+	d.CreatedAt = time.Now()
+	addOverrideFields(cfg, &d.Message)
+	if d.Message.AllowedMentions == nil {
+		// Only configured mentions may ping anyone.
+		d.Message.AllowedMentions = mentionSet{}.allowed()
+	}
+
+	logger := notificationLogger(alertManagerData).With("webhook", d.WebhookName)
+	parts := normalizeDelivery(d)
+	if len(parts) > 1 {
+		logger.Info("Message exceeds Discord limits, splitting it", "messages", len(parts))
+	}
+	var result deliveryResult
+	for _, part := range parts {
+		result.add(postDelivery(cfg, logger, part))
+	}
+	return result
+}
+
+// postDelivery sends or queues one message that fits Discord's limits.
+func postDelivery(cfg *Config, logger *slog.Logger, d *delivery) deliveryResult {
+	discordMessage := &d.Message
 	
-	// Validate message before sending
-	if !validateDiscordMessage(&discordMessage) {
-		log.Printf("Invalid Discord message structure, skipping send")
-		return
+	// Validate message before sending. Discord would reject it anyway, so
+	// it counts as failed rather than being dropped silently.
+	if !validateDiscordMessage(discordMessage) {
+		logger.Error("Invalid Discord message structure, not sending it")
+		deliveriesFailed.WithLabelValues(d.WebhookName, "invalid").Inc()
+		return deliveryResult{Failed: 1}
 	}
 	
 	discordMessageBytes, err := json.Marshal(discordMessage)
 	if err != nil {
-		log.Printf("Failed to marshal Discord message: %v", err)
-		return
+		logger.Error("Failed to marshal Discord message", "error", err)
+		deliveriesFailed.WithLabelValues(d.WebhookName, "marshal").Inc()
+		return deliveryResult{Failed: 1}
 	}
 	
-	if *verboseMode == "ON" || *verboseMode == "true" {
-		log.Printf("Sending webhook message to Discord: %s", string(discordMessageBytes))
-	}
+	logger.Debug("Sending webhook message to Discord", "payload", string(discordMessageBytes))
 	
-	sendToWebhook(*webhookURL, discordMessageBytes)
-	for _, webhook := range additionalWebhookURLs {
-		sendToWebhook(webhook, discordMessageBytes)
+	if cfg.Server.DeliveryMode == deliveryModeAsync {
+		err := deliveries.enqueue(d)
+		if err == nil {
+			return deliveryResult{Queued: 1}
+		}
+		logger.Error("Failed to queue message, sending directly", "error", err)
+	}
+
+	err = sendDelivery(cfg, d)
+	if err == nil {
+		return deliveryResult{Delivered: 1}
+	}
+
+	// Keep retrying in the background when possible rather than failing
+	// the whole notification.
+	if deliveries != nil && !isPermanentDeliveryError(err) {
+		if queueErr := deliveries.enqueue(d); queueErr == nil {
+			logger.Warn("Delivery failed, queued message for retry", "error", err)
+			return deliveryResult{Queued: 1}
+		}
+	}
+	deliveriesFailed.WithLabelValues(d.WebhookName, "send").Inc()
+	return deliveryResult{Failed: 1}
+}
+
+// sendDelivery makes one attempt at delivering a message. It edits
+// d.MessageID when set, and otherwise posts a new message, remembering its
+// ID when the message shows alerts that may be edited later. Messages of a
+// group with threads enabled go to the group's thread, which the first
+// message of the group starts. Queued messages are retried with the
+// configuration in effect at the time of each attempt, including the URL
+// of the webhook.
+func sendDelivery(cfg *Config, d *delivery) error {
+	webhookURL, ok := cfg.router.webhooks[d.WebhookName]
+	if !ok {
+		return fmt.Errorf("%w: %s", errUnknownWebhook, d.WebhookName)
+	}
+	discordMessageBytes, err := json.Marshal(d.Message)
+	if err != nil {
+		return err
+	}
+	// Attachments are uploaded as multipart, both for new messages and
+	// for edits.
+	var header http.Header
+	if len(d.Files) > 0 {
+		var contentType string
+		discordMessageBytes, contentType, err = multipartMessage(d)
+		if err != nil {
+			return err
+		}
+		header = http.Header{"Content-Type": {contentType}}
+	}
+
+	threadKey, thread := "", ""
+	if d.GroupKey != "" {
+		// Hold the group's lock until its thread exists, so concurrent
+		// deliveries do not start one thread each.
+		threadKey = threadStoreKey(d.WebhookName, d.GroupKey)
+		unlock := threads.lock(threadKey)
+		defer unlock()
+		thread = threads.get(threadKey)
+	}
+
+	if d.MessageID != "" {
+		query := url.Values{}
+		if d.ThreadID != "" {
+			query.Set("thread_id", d.ThreadID)
+		}
+		_, err := discordRequest(cfg, http.MethodPatch, d.WebhookName, webhookEndpoint(webhookURL, "/messages/"+d.MessageID, query), header, discordMessageBytes)
+		if !isNotFoundDeliveryError(err) {
+			if err == nil {
+				observeDelivery(d)
+				if d.CloseThread {
+					threads.forget(threadKey)
+				}
+			}
+			return err
+		}
+		// The message was deleted in Discord; post the update instead.
+		slog.Warn("Discord message to edit no longer exists, posting a new one", "webhook", d.WebhookName, "message_id", d.MessageID)
+	}
+
+	remember := len(d.Fingerprints) > 0 && d.MessageID == ""
+	startThread := d.GroupKey != "" && thread == ""
+	query := url.Values{}
+	if thread != "" {
+		query.Set("thread_id", thread)
+	}
+	if startThread && cfg.Discord.Threads.Mode == threadModeForum {
+		query.Set("thread_name", d.ThreadName)
+	}
+	if remember || startThread {
+		query.Set("wait", "true")
+	}
+	if len(d.Message.Components) > 0 {
+		query.Set("with_components", "true")
+	}
+	responseData, err := discordRequest(cfg, http.MethodPost, d.WebhookName, webhookEndpoint(webhookURL, "", query), header, discordMessageBytes)
+	if err != nil {
+		return err
+	}
+	observeDelivery(d)
+	if !remember && !startThread {
+		if d.CloseThread {
+			threads.forget(threadKey)
+		}
+		return nil
+	}
+
+	var posted struct {
+		ID        string `json:"id"`
+		ChannelID string `json:"channel_id"`
+	}
+	if err := json.Unmarshal(responseData, &posted); err != nil || posted.ID == "" {
+		slog.Warn("Discord did not return the posted message", "webhook", d.WebhookName, "error", err)
+		return nil
+	}
+
+	// The ID needed to edit the message later: messages inside a thread,
+	// including a forum post's first message, need the thread ID.
+	messageThread := thread
+	if startThread {
+		switch cfg.Discord.Threads.Mode {
+		case threadModeForum:
+			thread = posted.ChannelID
+			messageThread = thread
+		case threadModeMessage:
+			thread, err = startMessageThread(cfg, d, webhookURL, posted.ChannelID, posted.ID)
+			if err != nil {
+				slog.Warn("Failed to start Discord thread", "webhook", d.WebhookName, "groupKey", d.GroupKey, "error", err)
+			}
+		}
+		if thread != "" && !d.CloseThread {
+			slog.Info("Started Discord thread", "webhook", d.WebhookName, "groupKey", d.GroupKey, "thread_id", thread)
+			threads.record(threadKey, d.WebhookName, d.GroupKey, thread)
+		}
+	} else if d.CloseThread {
+		threads.forget(threadKey)
+	}
+
+	if remember {
+		sentMessages.record(&sentMessage{
+			ID:           posted.ID,
+			WebhookName:  d.WebhookName,
+			ThreadID:     messageThread,
+			Fingerprints: d.Fingerprints,
+			Content:      d.Message.Content,
+			Embeds:       d.Message.Embeds,
+			PostedAt:     time.Now(),
+		})
+	}
+	return nil
+}
+
+// observeDelivery records the metrics of a delivered message.
+func observeDelivery(d *delivery) {
+	embedsSent.WithLabelValues(d.WebhookName).Add(float64(len(d.Message.Embeds)))
+	deliveryLatency.WithLabelValues(d.WebhookName).Observe(time.Since(d.CreatedAt).Seconds())
+}
+
+// webhookEndpoint appends path to the path of webhookURL and adds query to
+// its query string.
+func webhookEndpoint(webhookURL string, path string, query url.Values) string {
+	u, err := url.Parse(webhookURL)
+	if err != nil {
+		return webhookURL
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	values := u.Query()
+	for name, value := range query {
+		values[name] = value
 	}
+	u.RawQuery = values.Encode()
+	return u.String()
 }
 
 // Validate Discord message structure
 func validateDiscordMessage(message *DiscordMessage) bool {
 	// Check if message has content
 	if message.Content == "" && len(message.Embeds) == 0 {
-		log.Printf("Message has no content or embeds")
+		slog.Warn("Message has no content or embeds")
+		validationRejections.WithLabelValues("no_content").Inc()
 		return false
 	}
 	
 	// Check total number of embeds (Discord limit is 10)
-	if len(message.Embeds) > 10 {
-		log.Printf("Message has too many embeds: %d (max: 10)", len(message.Embeds))
+	if len(message.Embeds) > discordMaxEmbeds {
+		slog.Warn("Message has too many embeds", "embeds", len(message.Embeds), "max", discordMaxEmbeds)
+		validationRejections.WithLabelValues("too_many_embeds").Inc()
+		return false
+	}
+
+	if len(message.Components) > discordMaxActionRows {
+		slog.Warn("Message has too many component rows", "rows", len(message.Components), "max", discordMaxActionRows)
+		validationRejections.WithLabelValues("too_many_components").Inc()
 		return false
 	}
 	
@@ -332,40 +735,40 @@ func validateDiscordMessage(message *DiscordMessage) bool {
 	
 	// Validate embeds
 	for i, embed := range message.Embeds {
-		embedSize := 0
-		
 		// Kiểm tra embed có content không
 		hasContent := embed.Title != "" || embed.Description != "" || len(embed.Fields) > 0
 		if !hasContent {
-			log.Printf("Embed %d has no content", i)
+			slog.Warn("Embed has no content", "embed", i)
+			validationRejections.WithLabelValues("empty_embed").Inc()
 			return false
 		}
 		
 		// Check Discord limits - Title
-		if len(embed.Title) > 256 {
-			log.Printf("Embed %d title too long: %d chars", i, len(embed.Title))
+		if length := utf8.RuneCountInString(embed.Title); length > discordMaxTitleChars {
+			slog.Warn("Embed title too long", "embed", i, "length", length)
+			validationRejections.WithLabelValues("title_too_long").Inc()
 			return false
 		}
-		embedSize += len(embed.Title)
 		
 		// Check Discord limits - Description  
-		if len(embed.Description) > 4096 {
-			log.Printf("Embed %d description too long: %d chars", i, len(embed.Description))
+		if length := utf8.RuneCountInString(embed.Description); length > discordMaxDescriptionChars {
+			slog.Warn("Embed description too long", "embed", i, "length", length)
+			validationRejections.WithLabelValues("description_too_long").Inc()
 			return false
 		}
-		embedSize += len(embed.Description)
 		
 		// Validate URL if present
 		if embed.URL != "" {
 			if _, err := url.Parse(embed.URL); err != nil {
-				log.Printf("Embed %d has invalid URL: %s", i, embed.URL)
+				slog.Warn("Embed has invalid URL", "embed", i, "url", embed.URL)
 				// Don't return false, just log warning
 			}
 		}
 		
 		// Check fields count
-		if len(embed.Fields) > 25 {
-			log.Printf("Embed %d has too many fields: %d", i, len(embed.Fields))
+		if len(embed.Fields) > discordMaxFields {
+			slog.Warn("Embed has too many fields", "embed", i, "fields", len(embed.Fields))
+			validationRejections.WithLabelValues("too_many_fields").Inc()
 			return false
 		}
 		
@@ -375,96 +778,133 @@ func validateDiscordMessage(message *DiscordMessage) bool {
 			fieldValue := strings.TrimSpace(field.Value)
 			
 			if fieldName == "" {
-				log.Printf("Embed %d field %d has empty name", i, j)
+				slog.Warn("Embed field has empty name", "embed", i, "field", j)
+				validationRejections.WithLabelValues("empty_field_name").Inc()
 				return false
 			}
 			if fieldValue == "" {
-				log.Printf("Embed %d field %d has empty value", i, j)
+				slog.Warn("Embed field has empty value", "embed", i, "field", j)
+				validationRejections.WithLabelValues("empty_field_value").Inc()
 				return false
 			}
-			if len(fieldName) > 256 {
-				log.Printf("Embed %d field %d name too long: %d chars", i, j, len(fieldName))
+			if length := utf8.RuneCountInString(fieldName); length > discordMaxFieldNameChars {
+				slog.Warn("Embed field name too long", "embed", i, "field", j, "length", length)
+				validationRejections.WithLabelValues("field_name_too_long").Inc()
 				return false
 			}
-			embedSize += len(fieldName)
 			
-			if len(fieldValue) > 1024 {
-				log.Printf("Embed %d field %d value too long: %d chars", i, j, len(fieldValue))
+			if length := utf8.RuneCountInString(fieldValue); length > discordMaxFieldValueChars {
+				slog.Warn("Embed field value too long", "embed", i, "field", j, "length", length)
+				validationRejections.WithLabelValues("field_value_too_long").Inc()
 				return false
 			}
-			embedSize += len(fieldValue)
 		}
 		
-		totalSize += embedSize
+		totalSize += embedLength(embed)
 	}
 	
 	// Check total message size (Discord limit is 6000 characters total)
-	if totalSize > 5000 {
-		log.Printf("Message too large: %d chars (max: 5000)", totalSize)
+	if totalSize > discordMaxEmbedChars {
+		slog.Warn("Message too large", "length", totalSize, "max", discordMaxEmbedChars)
+		validationRejections.WithLabelValues("message_too_large").Inc()
 		return false
 	}
 	
 	return true
 }
 
-func sendToWebhook(webHook string, discordMessageBytes []byte) {
-	// Add small delay to avoid rate limiting
-	time.Sleep(100 * time.Millisecond)
-	
-	response, err := http.Post(webHook, "application/json", bytes.NewReader(discordMessageBytes))
-	if err != nil {
-		log.Printf("HTTP Error: %v", err)
-		return
-	}
-	defer response.Body.Close()
-	
-	// Read response body for better error handling
-	responseData, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		log.Printf("Failed to read response body: %v", err)
-		return
-	}
-	
-	// Success is indicated with 2xx status codes:
-	statusOK := response.StatusCode >= 200 && response.StatusCode < 300
-	if !statusOK {
-		log.Printf("Discord API Error (Status %d): %s", response.StatusCode, string(responseData))
-		
-		// Handle specific Discord errors
-		if response.StatusCode == 400 {
-			log.Printf("Bad Request - Check embed structure and content length")
-		} else if response.StatusCode == 429 {
-			log.Printf("Rate Limited - Consider reducing message frequency")
+// discordRequest sends a request to the Discord API on behalf of the named
+// webhook and returns the response body. The body is JSON unless header
+// sets another Content-Type. Rate limits are waited out, up to the
+// configured limits.
+func discordRequest(cfg *Config, method string, webhookName string, webHook string, header http.Header, discordMessageBytes []byte) ([]byte, error) {
+	logger := slog.With("webhook", webhookName)
+	limits := cfg.Discord.RateLimit
+	minInterval := time.Duration(cfg.Discord.Formatting.RateLimitDelay) * time.Millisecond
+
+	for attempt := 0; ; attempt++ {
+		discordRateLimiter.wait(webHook, minInterval)
+
+		request, err := http.NewRequest(method, webHook, bytes.NewReader(discordMessageBytes))
+		if err != nil {
+			return nil, err
 		}
-	} else {
-		log.Printf("Successfully sent to Discord (Status: %d)", response.StatusCode)
-		if *verboseMode == "ON" || *verboseMode == "true" {
-			log.Printf("Discord Response: %s", string(responseData))
+		request.Header.Set("Content-Type", "application/json")
+		for name, values := range header {
+			request.Header[name] = values
+		}
+		response, err := cfg.httpClient.Do(request)
+		if err != nil {
+			logger.Error("HTTP request to Discord failed", "error", err)
+			observeDiscordResponse(webhookName, 0)
+			return nil, err
+		}
+		observeDiscordResponse(webhookName, response.StatusCode)
+
+		// Read response body for better error handling
+		responseData, err := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		if err != nil {
+			logger.Error("Failed to read Discord response body", "discord_status", response.StatusCode, "error", err)
+			return nil, err
+		}
+		discordRateLimiter.update(webHook, response.Header)
+
+		if response.StatusCode == http.StatusTooManyRequests {
+			retryAfter, global := discordRateLimiter.limited(webHook, response.Header, responseData)
+			scope := "bucket"
+			if global {
+				scope = "global"
+			}
+			rateLimitHits.WithLabelValues(webhookName, scope).Inc()
+			if attempt >= limits.MaxRetries || retryAfter > limits.MaxWait {
+				logger.Warn("Rate limited by Discord, giving up", "discord_status", response.StatusCode, "attempts", attempt+1, "retry_after", retryAfter, "scope", scope)
+				return nil, &deliveryError{StatusCode: response.StatusCode, Body: string(responseData)}
+			}
+			logger.Info("Rate limited by Discord, retrying", "discord_status", response.StatusCode, "retry_after", retryAfter, "scope", scope)
+			continue
+		}
+
+		// Success is indicated with 2xx status codes:
+		statusOK := response.StatusCode >= 200 && response.StatusCode < 300
+		if !statusOK {
+			// Handle specific Discord errors
+			hint := ""
+			if response.StatusCode == 400 {
+				hint = "Check embed structure and content length"
+			}
+			logger.Error("Discord API error", "discord_status", response.StatusCode, "response", string(responseData), "hint", hint)
+			return nil, &deliveryError{StatusCode: response.StatusCode, Body: string(responseData)}
 		}
+
+		logger.Info("Successfully sent to Discord", "discord_status", response.StatusCode)
+		logger.Debug("Discord response", "discord_status", response.StatusCode, "response", string(responseData))
+		return responseData, nil
 	}
 }
 
 func buildDiscordMessage(alertManagerData *AlertManagerData, status string, numberOfAlerts int, color int) DiscordMessage {
+	cfg := config()
 	discordMessage := DiscordMessage{}
-	addOverrideFields(&discordMessage)
+	addOverrideFields(cfg, &discordMessage)
 	
 	// Tạo header message an toàn
 	alertName := getAlertName(alertManagerData)
-	alertName = truncateString(alertName, maxTitleLength)
+	alertName = truncateString(alertName, cfg.Discord.Formatting.MaxTitleLength)
 	
 	// Đảm bảo title không rỗng
 	title := fmt.Sprintf("[%s] %s", strings.ToUpper(status), alertName)
 	if title == "" || len(strings.TrimSpace(title)) == 0 {
 		title = fmt.Sprintf("[%s] Alert", strings.ToUpper(status))
 	}
-	title = truncateString(title, maxTitleLength)
+	title = truncateString(title, cfg.Discord.Formatting.MaxTitleLength)
 	
 	// Tạo description an toàn
 	description := ""
 	if alertManagerData.CommonAnnotations["summary"] != "" {
-		description = truncateString(alertManagerData.CommonAnnotations["summary"], maxDescriptionLength)
+		description = truncateString(alertManagerData.CommonAnnotations["summary"], cfg.Discord.Formatting.MaxDescriptionLength)
 	} else if len(alertManagerData.Alerts) > 0 && alertManagerData.Alerts[0].Annotations["summary"] != "" {
-		description = truncateString(alertManagerData.Alerts[0].Annotations["summary"], maxDescriptionLength)
+		description = truncateString(alertManagerData.Alerts[0].Annotations["summary"], cfg.Discord.Formatting.MaxDescriptionLength)
 	}
 	
 	// Validate URL
@@ -473,7 +913,7 @@ func buildDiscordMessage(alertManagerData *AlertManagerData, status string, numb
 		if _, err := url.Parse(alertManagerData.ExternalURL); err == nil {
 			externalURL = alertManagerData.ExternalURL
 		} else {
-			log.Printf("Invalid external URL: %s, skipping", alertManagerData.ExternalURL)
+			slog.Warn("Invalid external URL, skipping", "url", alertManagerData.ExternalURL)
 		}
 	}
 	
@@ -490,8 +930,8 @@ func buildDiscordMessage(alertManagerData *AlertManagerData, status string, numb
 	}
 	
 	// Add timestamp to header
-	if *username != "" {
-		footer := DiscordEmbedFooter{Text: *username}
+	if cfg.Discord.Username != "" {
+		footer := DiscordEmbedFooter{Text: cfg.Discord.Username}
 		messageHeader.Footer = &footer
 		currentTime := time.Now()
 		messageHeader.Timestamp = &currentTime
@@ -501,19 +941,18 @@ func buildDiscordMessage(alertManagerData *AlertManagerData, status string, numb
 	return discordMessage
 }
 
-func addOverrideFields(discordMessage *DiscordMessage) {
-	if *username != "" {
-		discordMessage.Username = *username
+func addOverrideFields(cfg *Config, discordMessage *DiscordMessage) {
+	if cfg.Discord.Username != "" {
+		discordMessage.Username = cfg.Discord.Username
 	}
-	if *avatarURL != "" {
-		discordMessage.AvatarURL = *avatarURL
+	if cfg.Discord.AvatarURL != "" {
+		discordMessage.AvatarURL = cfg.Discord.AvatarURL
 	}
 }
 
-func getFormattedLabels(labels KV) string {
+func getFormattedLabels(labels KV, maxLabels int) string {
     var builder strings.Builder
     count := 0
-    maxLabels := 3
     
     for _, pair := range labels.SortedPairs() {
         if count >= maxLabels {
@@ -533,9 +972,7 @@ func getFormattedLabels(labels KV) string {
         }
         
         // Truncate value if too long
-        if len(value) > 25 {
-            value = value[:22] + "..."
-        }
+        value = truncateString(value, 25)
         
         builder.WriteString(fmt.Sprintf("• %s: %s\n", pair.Name, value))
         count++
@@ -620,11 +1057,8 @@ func getAlertName(alertManagerData *AlertManagerData) string {
 	if alertManagerData.CommonAnnotations["description"] != "" {
 		desc := alertManagerData.CommonAnnotations["description"]
 		// Truncate nếu quá dài và chỉ lấy dòng đầu tiên
-		if len(desc) > 50 {
-			desc = strings.Split(desc, "\n")[0]
-			if len(desc) > 50 {
-				desc = desc[:47] + "..."
-			}
+		if utf8.RuneCountInString(desc) > 50 {
+			desc = truncateString(strings.Split(desc, "\n")[0], 50)
 		}
 		return icon + desc
 	}
@@ -655,9 +1089,7 @@ func sendRawPromAlertWarn() {
 		`for guidance on how to configure it for alertmanager` + "\n" +
 		`or https://prometheus.io/docs/alerting/latest/configuration/#webhook_config`
 
-	log.Print(`/!\ -- You have misconfigured this program -- /!\`)
-	log.Print(`--- --                                      -- ---`)
-	log.Print(badString)
+	slog.Warn(`/!\ -- You have misconfigured this program -- /!\`, "details", badString)
 
 	discordMessage := DiscordMessage{
 		Content: "",
@@ -672,55 +1104,207 @@ func sendRawPromAlertWarn() {
 	}
 
 	discordMessageBytes, _ := json.Marshal(discordMessage)
-	http.Post(*webhookURL, "application/json", bytes.NewReader(discordMessageBytes))
+	cfg := config()
+	response, err := cfg.httpClient.Post(cfg.Discord.WebhookURL, "application/json", bytes.NewReader(discordMessageBytes))
+	if err != nil {
+		slog.Error("Failed to send misconfiguration warning to Discord", "error", err)
+		return
+	}
+	response.Body.Close()
 }
 
 func main() {
 	flag.Parse()
-	checkWebhookURL(*webhookURL)
-	for _, additionalWebhook := range strings.Split(*additionalWebhookURLFlag, ",") {
-		if isNotBlankOrEmpty(additionalWebhook) && checkWebhookURL(additionalWebhook) {
-			additionalWebhookURLs = append(additionalWebhookURLs, additionalWebhook)
+
+	loaded, err := loadConfig(*configFile)
+	if err != nil {
+		fatal("Failed to load configuration", "error", err)
+	}
+	currentConfig.Store(loaded)
+	if *healthcheck {
+		os.Exit(runHealthcheck())
+	}
+	logger, logCloser, err := newLogger(config().Logging, config().Server.Verbose)
+	if err != nil {
+		fatal("Failed to set up logging", "error", err)
+	}
+	defer logCloser.Close()
+	slog.SetDefault(logger)
+	if *configFile != "" {
+		slog.Info("Loaded configuration", "file", *configFile)
+	}
+	configReloadSuccess.Set(1)
+	configReloadTime.SetToCurrentTime()
+	reloadOnSignal()
+	if config().Server.WatchConfigFile {
+		if *configFile == "" {
+			slog.Warn("server.watch_config_file is set but no configuration file is used")
+		} else if err := watchConfigFile(*configFile); err != nil {
+			slog.Warn("Failed to watch configuration file, reload with SIGHUP instead", "file", *configFile, "error", err)
+		}
+	}
+
+	checkWebhookURL(config().Discord.WebhookURL)
+	checkDiscordUserName(config().Discord.Username)
+
+	sentMessages, err = openMessageStore(config().State)
+	if err != nil {
+		fatal("Failed to open message store", "error", err)
+	}
+	if config().Alerts.EditOnResolve && config().State.Directory == "" {
+		slog.Warn("state.directory is not set, messages can only be edited on resolve until the next restart")
+	}
+	threads, err = openThreadStore(config().State)
+	if err != nil {
+		fatal("Failed to open thread store", "error", err)
+	}
+	firingAlerts, err = openAlertStore(config().State)
+	if err != nil {
+		fatal("Failed to open alert store", "error", err)
+	}
+	if len(config().escalation) > 0 && config().State.Directory == "" {
+		slog.Warn("state.directory is not set, escalations are sent again after a restart")
+	}
+	go runEscalations(config().Escalation.Interval)
+	if config().interactionsEnabled() && config().Discord.Interactions.ApplicationID != "" {
+		go func() {
+			if err := registerSilenceCommand(); err != nil {
+				slog.Warn("Failed to register the /silence command", "error", err)
+			}
+		}()
+	}
+	if config().Discord.Threads.Mode != "" && config().State.Directory == "" {
+		slog.Warn("state.directory is not set, alert groups start new threads after a restart")
+	}
+
+	if config().Queue.Directory != "" {
+		deliveries, err = openDeliveryQueue(config().Queue)
+		if err != nil {
+			fatal("Failed to open delivery queue", "error", err)
 		}
+		deliveries.start(func(d *delivery) error {
+			return sendDelivery(config(), d)
+		})
+		slog.Info("Delivery queue enabled", "directory", config().Queue.Directory, "mode", config().Server.DeliveryMode)
 	}
-	checkDiscordUserName(*username)
 
-	if *listenAddress == "" {
-		*listenAddress = defaultListenAddress
+	timeout := time.Duration(config().Server.Timeout) * time.Second
+	server := &http.Server{
+		Addr:         config().Server.ListenAddress,
+		Handler:      snapshotConfig(newServeMux()),
+		ReadTimeout:  timeout,
+		WriteTimeout: timeout,
 	}
 
-	log.Printf("Listening on: %s", *listenAddress)
-	log.Fatal(http.ListenAndServe(*listenAddress, http.HandlerFunc(handleWebHook)))
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	if config().Server.TLS.enabled() {
+		server.TLSConfig, err = newServerTLSConfig(config().Server.TLS)
+		if err != nil {
+			fatal("Failed to set up TLS", "error", err)
+		}
+		slog.Info("Listening with TLS", "address", config().Server.ListenAddress, "client_auth", server.TLSConfig.ClientAuth)
+		go func() { serveErr <- server.ListenAndServeTLS("", "") }()
+	} else {
+		slog.Info("Listening", "address", config().Server.ListenAddress)
+		go func() { serveErr <- server.ListenAndServe() }()
+	}
+
+	select {
+	case err := <-serveErr:
+		fatal("HTTP server stopped", "error", err)
+	case <-ctx.Done():
+	}
+	// A second signal kills the process right away.
+	stop()
+	shutdown(server)
+}
+
+// shutdown stops accepting requests, then waits for the requests being
+// handled and the queued deliveries until server.shutdown_timeout has
+// passed. Undelivered messages stay in the queue for the next start.
+func shutdown(server *http.Server) {
+	timeout := config().Server.ShutdownTimeout
+	slog.Info("Shutting down", "timeout", timeout)
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Warn("Shutdown deadline reached with requests still being handled", "error", err)
+	}
+	if deliveries != nil {
+		if !deliveries.drain(ctx) {
+			slog.Warn("Shutdown deadline reached with deliveries in progress")
+		}
+		if err := deliveries.close(ctx); err != nil {
+			slog.Error("Failed to close delivery queue", "error", err)
+		}
+		if pending := deliveries.depth(); pending > 0 {
+			slog.Info("Undelivered messages are kept for the next start", "messages", pending, "directory", config().Queue.Directory)
+		}
+	}
+	if firingAlerts != nil {
+		firingAlerts.flush()
+	}
+	slog.Info("Shutdown complete")
 }
 
 func handleWebHook(w http.ResponseWriter, r *http.Request) {
-	log.Printf("%s - [%s] %s", r.Host, r.Method, r.URL.RawPath)
+	slog.Info("Received request", "host", r.Host, "remote", r.RemoteAddr, "method", r.Method, "path", r.URL.Path)
+	cfg := requestConfig(r)
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "alerts must be POSTed by Alertmanager", http.StatusMethodNotAllowed)
+		return
+	}
 
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		panic(err)
+		readBodyError(w, err)
+		return
 	}
 
-	if *verboseMode == "ON" {
-		log.Printf("request payload: %s", string(body))
-	}
+	slog.Debug("Request payload", "payload", string(body))
 
 	alertManagerData := AlertManagerData{}
 	err = json.Unmarshal(body, &alertManagerData)
 	if err != nil {
+		notificationsReceived.WithLabelValues("", "invalid").Inc()
 		if isRawPromAlert(body) {
 			sendRawPromAlertWarn()
+			http.Error(w, "expected an Alertmanager webhook payload, got raw Prometheus alerts", http.StatusBadRequest)
 			return
 		}
-		if len(body) > 1024 {
-			log.Printf("Failed to unpack inbound alert request - %s...", string(body[:1023]))
-
-		} else {
-			log.Printf("Failed to unpack inbound alert request - %s", string(body))
-		}
+		payload, _ := truncateText(string(body), 1024)
+		slog.Warn("Failed to unpack inbound alert request", "error", err, "payload", payload)
+		http.Error(w, "malformed Alertmanager payload", http.StatusBadRequest)
 		return
 	}
-	sendWebhook(&alertManagerData)
+
+	notificationsReceived.WithLabelValues(receiverLabel(cfg, alertManagerData.Receiver), statusLabel(alertManagerData.Status)).Inc()
+	for _, alert := range alertManagerData.Alerts {
+		alertsProcessed.WithLabelValues(statusLabel(alert.Status)).Inc()
+	}
+	firingAlerts.update(&alertManagerData)
+
+	result := sendWebhook(cfg, &alertManagerData)
+	switch {
+	case result.allFailed():
+		// Alertmanager retries notifications that fail with a 5xx status.
+		notificationLogger(&alertManagerData).Error("Failed to deliver notification to Discord", "messages", result.Failed)
+		http.Error(w, "failed to deliver notification to Discord", http.StatusBadGateway)
+	case cfg.Server.DeliveryMode == deliveryModeAsync:
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
 }
 
 // isValidField kiểm tra field có hợp lệ không
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// withConfig makes cfg the configuration in effect until the test ends.
+func withConfig(t *testing.T, cfg *Config) {
+	t.Helper()
+	previous := config()
+	currentConfig.Store(cfg)
+	t.Cleanup(func() { currentConfig.Store(previous) })
+}
+
+func TestPostDeliveryFailsInvalidMessage(t *testing.T) {
+	failed := deliveriesFailed.WithLabelValues("test-invalid", "invalid")
+	before := testutil.ToFloat64(failed)
+
+	d := &delivery{WebhookName: "test-invalid"}
+	result := postDelivery(defaultConfig(), slog.New(slog.NewTextHandler(io.Discard, nil)), d)
+
+	if result.Failed != 1 || result.Delivered != 0 || result.Queued != 0 {
+		t.Fatalf("result = %+v, want one failed message", result)
+	}
+	if !result.allFailed() {
+		t.Error("allFailed() = false, so Alertmanager would not retry")
+	}
+	if got := testutil.ToFloat64(failed) - before; got != 1 {
+		t.Errorf("deliveries_failed_total{reason=\"invalid\"} increased by %v, want 1", got)
+	}
+}
+
+// fakeDiscord counts the messages posted to it.
+func fakeDiscord(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var posted atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posted.Add(1)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &posted
+}
+
+// testWebhookConfig returns a configuration with the named webhooks.
+func testWebhookConfig(t *testing.T, webhooks map[string]string) *Config {
+	t.Helper()
+	cfg := defaultConfig()
+	router, err := newAlertRouter(webhooks, nil, RoutingConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.router = router
+	cfg.httpClient = http.DefaultClient
+	return cfg
+}
+
+func TestSendDeliveryUsesCurrentWebhookURL(t *testing.T) {
+	old, oldPosted := fakeDiscord(t)
+	rotated, rotatedPosted := fakeDiscord(t)
+	d := &delivery{WebhookName: "team", Message: DiscordMessage{Content: "hello"}}
+
+	if err := sendDelivery(testWebhookConfig(t, map[string]string{"team": old.URL}), d); err != nil {
+		t.Fatalf("sendDelivery: %v", err)
+	}
+	// A reload rotated the webhook; the next attempt must follow it.
+	if err := sendDelivery(testWebhookConfig(t, map[string]string{"team": rotated.URL}), d); err != nil {
+		t.Fatalf("sendDelivery after reload: %v", err)
+	}
+	if oldPosted.Load() != 1 || rotatedPosted.Load() != 1 {
+		t.Errorf("posted to old URL %d times and to rotated URL %d times, want once each", oldPosted.Load(), rotatedPosted.Load())
+	}
+
+	record, err := json.Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(record), rotated.URL) {
+		t.Errorf("queue record contains the webhook URL: %s", record)
+	}
+}
+
+func TestSendDeliveryToRemovedWebhookIsPermanent(t *testing.T) {
+	d := &delivery{WebhookName: "removed", Message: DiscordMessage{Content: "hello"}}
+	err := sendDelivery(testWebhookConfig(t, map[string]string{"team": "http://127.0.0.1:0"}), d)
+	if !errors.Is(err, errUnknownWebhook) {
+		t.Fatalf("sendDelivery = %v, want errUnknownWebhook", err)
+	}
+	if !isPermanentDeliveryError(err) {
+		t.Error("a removed webhook would be retried until max_age")
+	}
+}
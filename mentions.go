@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DiscordAllowedMentions limits who a message may ping. Mentions in the
+// content that are not listed here are shown but do not notify anyone.
+type DiscordAllowedMentions struct {
+	Parse []string `json:"parse"`
+	Roles []string `json:"roles,omitempty"`
+	Users []string `json:"users,omitempty"`
+}
+
+var snowflakeRe = regexp.MustCompile(`^[0-9]{17,20}$`)
+
+// mentionRule pings roles, users or @here for firing alerts that match its
+// matchers.
+type mentionRule struct {
+	matchers labelMatchers
+	webhooks []string
+	mentions mentionSet
+}
+
+// mentionSet is the roles and users a message pings.
+type mentionSet struct {
+	roles    []string
+	users    []string
+	here     bool
+	everyone bool
+}
+
+// newMentionRules compiles the mentions section. Webhook names are checked
+// against router.
+func newMentionRules(cfg []MentionConfig, router *alertRouter) ([]mentionRule, error) {
+	var rules []mentionRule
+	for i, mc := range cfg {
+		where := fmt.Sprintf("mentions[%d]", i)
+		matchers, err := parseLabelMatchers(mc.Matchers)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", where, err)
+		}
+		if err := router.checkNames(where, mc.Webhooks); err != nil {
+			return nil, err
+		}
+		if err := checkMentionIDs(where, mc.Roles, mc.Users); err != nil {
+			return nil, err
+		}
+		if len(mc.Roles) == 0 && len(mc.Users) == 0 && !mc.Here && !mc.Everyone {
+			return nil, fmt.Errorf("%s: nobody to mention", where)
+		}
+		rules = append(rules, mentionRule{
+			matchers: matchers,
+			webhooks: mc.Webhooks,
+			mentions: mentionSet{roles: mc.Roles, users: mc.Users, here: mc.Here, everyone: mc.Everyone},
+		})
+	}
+	return rules, nil
+}
+
+// checkMentionIDs checks that role and user IDs look like Discord IDs.
+func checkMentionIDs(where string, roles, users []string) error {
+	for _, id := range append(append([]string(nil), roles...), users...) {
+		if !snowflakeRe.MatchString(id) {
+			return fmt.Errorf("%s: %q is not a Discord ID", where, id)
+		}
+	}
+	return nil
+}
+
+// mentionsFor returns everyone to ping for alert on the named webhook.
+// Resolved alerts ping nobody.
+func mentionsFor(cfg *Config, webhook string, alert *AlertManagerAlert) mentionSet {
+	var mentions mentionSet
+	if alert.Status != "firing" {
+		return mentions
+	}
+	for _, rule := range cfg.mentions {
+		if len(rule.webhooks) > 0 && !containsString(rule.webhooks, webhook) {
+			continue
+		}
+		if rule.matchers.matches(alert.Labels) {
+			mentions.add(rule.mentions)
+		}
+	}
+	return mentions
+}
+
+func (m *mentionSet) add(other mentionSet) {
+	for _, role := range other.roles {
+		if !containsString(m.roles, role) {
+			m.roles = append(m.roles, role)
+		}
+	}
+	for _, user := range other.users {
+		if !containsString(m.users, user) {
+			m.users = append(m.users, user)
+		}
+	}
+	m.here = m.here || other.here
+	m.everyone = m.everyone || other.everyone
+}
+
+// content renders the mentions for the message content, or "" when there
+// are none.
+func (m mentionSet) content() string {
+	var parts []string
+	for _, role := range m.roles {
+		parts = append(parts, "<@&"+role+">")
+	}
+	for _, user := range m.users {
+		parts = append(parts, "<@"+user+">")
+	}
+	if m.everyone {
+		parts = append(parts, "@everyone")
+	} else if m.here {
+		parts = append(parts, "@here")
+	}
+	return strings.Join(parts, " ")
+}
+
+// allowed returns the allowed_mentions object that pings exactly m.
+// @here and @everyone are both enabled by Discord's "everyone" type.
+func (m mentionSet) allowed() *DiscordAllowedMentions {
+	allowed := &DiscordAllowedMentions{Parse: []string{}, Roles: m.roles, Users: m.users}
+	if m.here || m.everyone {
+		allowed.Parse = append(allowed.Parse, "everyone")
+	}
+	return allowed
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+const (
+	testRoleID = "123456789012345678"
+	testUserID = "234567890123456789"
+)
+
+func TestMentionSetAllowedMentions(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		mentions mentionSet
+		content  string
+		allowed  string
+	}{
+		{"nobody", mentionSet{}, "", `{"parse":[]}`},
+		{"role", mentionSet{roles: []string{testRoleID}}, "<@&" + testRoleID + ">", `{"parse":[],"roles":["` + testRoleID + `"]}`},
+		{"user", mentionSet{users: []string{testUserID}}, "<@" + testUserID + ">", `{"parse":[],"users":["` + testUserID + `"]}`},
+		{"here", mentionSet{here: true}, "@here", `{"parse":["everyone"]}`},
+		{"everyone wins over here", mentionSet{here: true, everyone: true}, "@everyone", `{"parse":["everyone"]}`},
+		{"all of them", mentionSet{roles: []string{testRoleID}, users: []string{testUserID}, here: true},
+			"<@&" + testRoleID + "> <@" + testUserID + "> @here",
+			`{"parse":["everyone"],"roles":["` + testRoleID + `"],"users":["` + testUserID + `"]}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.mentions.content(); got != tc.content {
+				t.Errorf("content = %q, want %q", got, tc.content)
+			}
+			allowed, err := json.Marshal(tc.mentions.allowed())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(allowed) != tc.allowed {
+				t.Errorf("allowed_mentions = %s, want %s", allowed, tc.allowed)
+			}
+		})
+	}
+}
+
+func TestMentionsFor(t *testing.T) {
+	cfg := testWebhookConfig(t, map[string]string{"default": "a", "oncall": "b"})
+	rules, err := newMentionRules([]MentionConfig{
+		{Matchers: []string{`severity="critical"`}, Roles: []string{testRoleID}, Here: true},
+		{Matchers: []string{`team="db"`}, Users: []string{testUserID}, Webhooks: []string{"oncall"}},
+		{Matchers: []string{`team=~"db|web"`}, Roles: []string{testRoleID}},
+	}, cfg.router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.mentions = rules
+
+	for _, tc := range []struct {
+		name    string
+		webhook string
+		status  string
+		labels  KV
+		content string
+	}{
+		{"no rule matches", "default", "firing", KV{"team": "ops"}, ""},
+		{"one rule", "default", "firing", KV{"severity": "critical"}, "<@&" + testRoleID + "> @here"},
+		{"rules add up without repeats", "default", "firing", KV{"severity": "critical", "team": "web"}, "<@&" + testRoleID + "> @here"},
+		{"rule for another webhook", "default", "firing", KV{"team": "db"}, "<@&" + testRoleID + ">"},
+		{"rule for this webhook", "oncall", "firing", KV{"team": "db"}, "<@&" + testRoleID + "> <@" + testUserID + ">"},
+		{"resolved alerts ping nobody", "oncall", "resolved", KV{"severity": "critical", "team": "db"}, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mentions := mentionsFor(cfg, tc.webhook, &AlertManagerAlert{Status: tc.status, Labels: tc.labels})
+			if got := mentions.content(); got != tc.content {
+				t.Errorf("mentions = %q, want %q", got, tc.content)
+			}
+		})
+	}
+}
+
+func TestNewMentionRulesErrors(t *testing.T) {
+	router, err := newAlertRouter(map[string]string{"default": "a"}, []string{"default"}, RoutingConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, mc := range map[string]MentionConfig{
+		"nobody to mention": {Matchers: []string{`a="b"`}},
+		"role name":         {Roles: []string{"oncall"}},
+		"short user ID":     {Users: []string{"1234"}},
+		"unknown webhook":   {Here: true, Webhooks: []string{"missing"}},
+		"bad matcher":       {Here: true, Matchers: []string{`a=~"("`}},
+	} {
+		if _, err := newMentionRules([]MentionConfig{mc}, router); err == nil {
+			t.Errorf("%s: newMentionRules accepted the entry", name)
+		}
+	}
+}
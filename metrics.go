@@ -0,0 +1,137 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "alertmanager_discord"
+
+var (
+	notificationsReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "notifications_received_total",
+		Help:      "Alertmanager webhook payloads received, by receiver and status. Receivers not listed in metrics.receivers and unknown statuses are \"other\"; unparseable payloads have status \"invalid\".",
+	}, []string{"receiver", "status"})
+
+	alertsProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "alerts_processed_total",
+		Help:      "Alerts contained in received notifications, by alert status.",
+	}, []string{"status"})
+
+	embedsSent = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "embeds_sent_total",
+		Help:      "Embeds successfully delivered to Discord, by webhook.",
+	}, []string{"webhook"})
+
+	discordResponses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "discord_responses_total",
+		Help:      "HTTP responses received from Discord, by webhook and status code. Transport errors have code \"error\".",
+	}, []string{"webhook", "code"})
+
+	rateLimitHits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "rate_limit_hits_total",
+		Help:      "429 responses from Discord, by webhook and scope (bucket or global).",
+	}, []string{"webhook", "scope"})
+
+	validationRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "validation_rejections_total",
+		Help:      "Messages rejected before sending because they violate Discord limits, by reason.",
+	}, []string{"reason"})
+
+	deliveriesFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "deliveries_failed_total",
+		Help:      "Messages that could neither be delivered nor queued for retry, by webhook and reason (invalid, marshal or send).",
+	}, []string{"webhook", "reason"})
+
+	authFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "auth_failures_total",
+		Help:      "Requests rejected with 401 because of missing or wrong credentials or signatures, by reason.",
+	}, []string{"reason"})
+
+	forbiddenRequests = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "forbidden_requests_total",
+		Help:      "Requests rejected with 403 because the client address is not allowed.",
+	})
+
+	configReloadSuccess = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "config_last_reload_successful",
+		Help:      "Whether the last configuration reload attempt was successful.",
+	})
+
+	configReloadTime = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "config_last_reload_success_timestamp_seconds",
+		Help:      "Timestamp of the last successful configuration load or reload.",
+	})
+
+	truncations = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "truncations_total",
+		Help:      "Texts shortened to fit Discord or configured length limits.",
+	})
+
+	deliveryLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "delivery_latency_seconds",
+		Help:      "Time from rendering a message to its successful delivery to Discord, including queueing and retries.",
+		Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300, 900, 3600},
+	}, []string{"webhook"})
+
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "queue_depth",
+		Help:      "Messages in the persistent delivery queue that are not yet delivered.",
+	}, func() float64 {
+		if deliveries == nil {
+			return 0
+		}
+		return float64(deliveries.depth())
+	})
+)
+
+// otherLabelValue replaces label values taken from requests that are not
+// known in advance.
+const otherLabelValue = "other"
+
+// receiverLabel returns the receiver label for a notification to receiver,
+// given the receivers cfg counts by name.
+func receiverLabel(cfg *Config, receiver string) string {
+	if containsString(cfg.Metrics.Receivers, receiver) {
+		return receiver
+	}
+	return otherLabelValue
+}
+
+// statusLabel returns the status label for an alert or notification status.
+func statusLabel(status string) string {
+	switch status {
+	case "firing", "resolved":
+		return status
+	}
+	return otherLabelValue
+}
+
+// observeDiscordResponse counts a Discord response; code 0 means the
+// request failed before a response arrived.
+func observeDiscordResponse(webhook string, code int) {
+	label := "error"
+	if code != 0 {
+		label = strconv.Itoa(code)
+	}
+	discordResponses.WithLabelValues(webhook, label).Inc()
+}
+
+var metricsHandler = promhttp.Handler()
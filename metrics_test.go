@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestReceiverAndStatusLabels(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Metrics.Receivers = []string{"discord"}
+
+	for _, tc := range []struct {
+		receiver, want string
+	}{
+		{"discord", "discord"},
+		{"", otherLabelValue},
+		{"discord-2", otherLabelValue},
+	} {
+		if got := receiverLabel(cfg, tc.receiver); got != tc.want {
+			t.Errorf("receiverLabel(%q) = %q, want %q", tc.receiver, got, tc.want)
+		}
+	}
+	for _, tc := range []struct {
+		status, want string
+	}{
+		{"firing", "firing"},
+		{"resolved", "resolved"},
+		{"FIRING", otherLabelValue},
+		{"x-random-1234", otherLabelValue},
+	} {
+		if got := statusLabel(tc.status); got != tc.want {
+			t.Errorf("statusLabel(%q) = %q, want %q", tc.status, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"strings"
+	"unicode/utf8"
+)
+
+// Discord limits for the parts of an embed.
+const (
+	discordMaxTitleChars       = 256
+	discordMaxDescriptionChars = 4096
+	discordMaxFields           = 25
+	discordMaxFieldNameChars   = 256
+	discordMaxFieldValueChars  = 1024
+	discordMaxFooterChars      = 2048
+
+	// contentFileName is the name of the attachment that carries content
+	// too long for the message itself.
+	contentFileName = "message.txt"
+	// emptyFieldText stands in for empty field names and values, which
+	// Discord rejects.
+	emptyFieldText = "\u200b"
+)
+
+// deliveryFile is a file attached to a delivered message.
+type deliveryFile struct {
+	Name    string `json:"name"`
+	Content string `json:"content"`
+}
+
+// chunkText splits s into pieces of at most n characters.
+func chunkText(s string, n int) []string {
+	var chunks []string
+	for s != "" {
+		var head string
+		head, s = splitText(s, n)
+		chunks = append(chunks, head)
+	}
+	return chunks
+}
+
+// normalizeEmbed rewrites embed into one or more embeds that each satisfy
+// Discord's limits. Text that does not fit is moved into continuation
+// embeds of the same color rather than cut off.
+func normalizeEmbed(embed DiscordEmbed) DiscordEmbeds {
+	if utf8.RuneCountInString(embed.Title) > discordMaxTitleChars {
+		// Keep the full title readable at the top of the description.
+		embed.Description = strings.TrimSpace(embed.Title + "\n\n" + embed.Description)
+		embed.Title, _ = splitText(embed.Title, discordMaxTitleChars-3)
+		embed.Title += "..."
+	}
+	if embed.Footer != nil && utf8.RuneCountInString(embed.Footer.Text) > discordMaxFooterChars {
+		embed.Footer = &DiscordEmbedFooter{Text: truncateString(embed.Footer.Text, discordMaxFooterChars)}
+	}
+
+	var fields DiscordEmbedFields
+	for _, field := range embed.Fields {
+		if strings.TrimSpace(field.Name) == "" {
+			field.Name = emptyFieldText
+		}
+		if strings.TrimSpace(field.Value) == "" {
+			field.Value = emptyFieldText
+		}
+		field.Name = truncateString(field.Name, discordMaxFieldNameChars)
+		for i, value := range chunkText(field.Value, discordMaxFieldValueChars) {
+			part := DiscordEmbedField{Name: field.Name, Value: value, Inline: field.Inline}
+			if i > 0 {
+				part.Name = truncateString(field.Name+" (continued)", discordMaxFieldNameChars)
+				part.Inline = false
+			}
+			fields = append(fields, part)
+		}
+	}
+
+	// The first embed also carries the title and footer, so its part of
+	// the description must leave room for them within the embed limit.
+	first := embed
+	first.Description, first.Fields = "", nil
+	budget := min(discordMaxDescriptionChars, discordMaxEmbedChars-embedLength(first))
+	var rest string
+	first.Description, rest = splitText(embed.Description, budget)
+	embeds := DiscordEmbeds{first}
+	for _, description := range chunkText(rest, discordMaxDescriptionChars) {
+		embeds = append(embeds, DiscordEmbed{Description: description, Color: embed.Color})
+	}
+
+	// Fill the last embed with fields, starting a continuation embed when
+	// it runs out of fields or characters.
+	current := &embeds[len(embeds)-1]
+	for _, field := range fields {
+		length := utf8.RuneCountInString(field.Name) + utf8.RuneCountInString(field.Value)
+		if len(current.Fields) >= discordMaxFields || embedLength(*current)+length > discordMaxEmbedChars {
+			embeds = append(embeds, DiscordEmbed{Color: embed.Color})
+			current = &embeds[len(embeds)-1]
+		}
+		current.Fields = append(current.Fields, field)
+	}
+
+	// An embed without title, description and fields shows nothing.
+	valid := embeds[:0]
+	for _, e := range embeds {
+		if e.Title != "" || e.Description != "" || len(e.Fields) > 0 {
+			valid = append(valid, e)
+		}
+	}
+	return valid
+}
+
+// normalizeDelivery rewrites the message of d so it satisfies Discord's
+// limits, splitting it into several deliveries where needed: oversized
+// embeds are split by normalizeEmbed, messages with too many embeds or
+// characters are split in order, and content over 2000 characters is
+// attached as a text file. Only the first delivery keeps d's MessageID and
+// buttons, and only the last closes the thread.
+func normalizeDelivery(d *delivery) []*delivery {
+	var embeds DiscordEmbeds
+	var fingerprints []string
+	urls := make(map[string]bool)
+	for i, embed := range d.Message.Embeds {
+		// Discord merges embeds that share a URL into the first one, so
+		// alerts of the same rule keep their graph link only once.
+		if urls[embed.URL] {
+			embed.URL = ""
+		} else if embed.URL != "" {
+			urls[embed.URL] = true
+		}
+		for _, e := range normalizeEmbed(embed) {
+			embeds = append(embeds, e)
+			if d.Fingerprints != nil {
+				fingerprints = append(fingerprints, d.Fingerprints[i])
+			}
+		}
+	}
+
+	first := *d
+	first.Message.Embeds = nil
+	first.Fingerprints = nil
+	if utf8.RuneCountInString(first.Message.Content) > discordMaxContentChars {
+		first.Files = append(first.Files, deliveryFile{Name: contentFileName, Content: first.Message.Content})
+		note := "\n… (full text attached as " + contentFileName + ")"
+		first.Message.Content, _ = splitText(first.Message.Content, discordMaxContentChars-utf8.RuneCountInString(note))
+		first.Message.Content += note
+	}
+
+	deliveries := []*delivery{&first}
+	current, chars := &first, 0
+	for i, embed := range embeds {
+		length := embedLength(embed)
+		if len(current.Message.Embeds) > 0 &&
+			(len(current.Message.Embeds) >= discordMaxEmbeds || chars+length > discordMaxEmbedChars) {
+			next := *d
+			next.Message = DiscordMessage{
+				Username:        d.Message.Username,
+				AvatarURL:       d.Message.AvatarURL,
+				AllowedMentions: mentionSet{}.allowed(),
+			}
+			next.MessageID, next.ThreadID, next.Files, next.Fingerprints = "", "", nil, nil
+			current.CloseThread = false
+			deliveries = append(deliveries, &next)
+			current, chars = &next, 0
+		}
+		current.Message.Embeds = append(current.Message.Embeds, embed)
+		if fingerprints != nil {
+			current.Fingerprints = append(current.Fingerprints, fingerprints[i])
+		}
+		chars += length
+	}
+
+	for _, part := range deliveries {
+		remember := false
+		for _, fingerprint := range part.Fingerprints {
+			remember = remember || fingerprint != ""
+		}
+		if !remember {
+			part.Fingerprints = nil
+		}
+	}
+	return deliveries
+}
+
+type discordAttachment struct {
+	ID       int    `json:"id"`
+	Filename string `json:"filename"`
+}
+
+// multipartMessage encodes the message of d together with its files as
+// multipart/form-data, the way Discord expects uploads.
+func multipartMessage(d *delivery) ([]byte, string, error) {
+	payload := struct {
+		DiscordMessage
+		Attachments []discordAttachment `json:"attachments"`
+	}{DiscordMessage: d.Message}
+	for i, file := range d.Files {
+		payload.Attachments = append(payload.Attachments, discordAttachment{ID: i, Filename: file.Name})
+	}
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	if err := writer.WriteField("payload_json", string(payloadJSON)); err != nil {
+		return nil, "", err
+	}
+	for i, file := range d.Files {
+		part, err := writer.CreateFormFile(fmt.Sprintf("files[%d]", i), file.Name)
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := part.Write([]byte(file.Content)); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, "", err
+	}
+	return body.Bytes(), writer.FormDataContentType(), nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// checkEmbedLimits fails the test if any embed breaks Discord's limits.
+func checkEmbedLimits(t *testing.T, embeds DiscordEmbeds) {
+	t.Helper()
+	for i, embed := range embeds {
+		if n := embedLength(embed); n > discordMaxEmbedChars {
+			t.Errorf("embed %d has %d characters, more than %d", i, n, discordMaxEmbedChars)
+		}
+		if n := utf8.RuneCountInString(embed.Description); n > discordMaxDescriptionChars {
+			t.Errorf("embed %d has a %d character description, more than %d", i, n, discordMaxDescriptionChars)
+		}
+		if n := len(embed.Fields); n > discordMaxFields {
+			t.Errorf("embed %d has %d fields, more than %d", i, n, discordMaxFields)
+		}
+	}
+}
+
+func TestNormalizeEmbed(t *testing.T) {
+	var fields DiscordEmbedFields
+	for i := 0; i < 30; i++ {
+		fields = append(fields, DiscordEmbedField{Name: fmt.Sprintf("field %d", i), Value: "value"})
+	}
+
+	for _, tc := range []struct {
+		name   string
+		embed  DiscordEmbed
+		embeds int
+	}{
+		{"fits", DiscordEmbed{Title: "title", Description: "description"}, 1},
+		{"long description", DiscordEmbed{Description: strings.Repeat("a ", 3000)}, 2},
+		{"full title and footer", DiscordEmbed{
+			Title:       strings.Repeat("t", discordMaxTitleChars),
+			Description: strings.Repeat("d", discordMaxDescriptionChars),
+			Footer:      &DiscordEmbedFooter{Text: strings.Repeat("f", discordMaxFooterChars)},
+		}, 2},
+		{"more than 25 fields", DiscordEmbed{Title: "title", Fields: fields}, 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			embeds := normalizeEmbed(tc.embed)
+			if len(embeds) != tc.embeds {
+				t.Errorf("got %d embeds, want %d", len(embeds), tc.embeds)
+			}
+			checkEmbedLimits(t, embeds)
+
+			var description strings.Builder
+			var got DiscordEmbedFields
+			for _, embed := range embeds {
+				description.WriteString(embed.Description)
+				got = append(got, embed.Fields...)
+			}
+			if description.String() != tc.embed.Description {
+				t.Error("description was not kept in full across the embeds")
+			}
+			if len(got) != len(tc.embed.Fields) {
+				t.Errorf("got %d fields, want %d", len(got), len(tc.embed.Fields))
+			}
+			if embeds[0].Title != tc.embed.Title {
+				t.Errorf("title = %q, want %q", embeds[0].Title, tc.embed.Title)
+			}
+		})
+	}
+}
+
+func TestNormalizeDeliveryAttachesLongContent(t *testing.T) {
+	content := strings.Repeat("x", discordMaxContentChars+1)
+	deliveries := normalizeDelivery(&delivery{Message: DiscordMessage{Content: content}})
+
+	if len(deliveries) != 1 {
+		t.Fatalf("got %d deliveries, want 1", len(deliveries))
+	}
+	d := deliveries[0]
+	if n := utf8.RuneCountInString(d.Message.Content); n > discordMaxContentChars {
+		t.Errorf("content has %d characters, more than %d", n, discordMaxContentChars)
+	}
+	if len(d.Files) != 1 || d.Files[0].Name != contentFileName || d.Files[0].Content != content {
+		t.Errorf("files = %+v, want the full content as %s", d.Files, contentFileName)
+	}
+}
+
+func TestSendDeliveryEditUploadsAttachments(t *testing.T) {
+	var method, attachment string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil {
+			t.Errorf("Content-Type %q: %v", r.Header.Get("Content-Type"), err)
+			return
+		}
+		form, err := multipart.NewReader(r.Body, params["boundary"]).ReadForm(1 << 20)
+		if err != nil {
+			t.Errorf("reading multipart body: %v", err)
+			return
+		}
+		if files := form.File["files[0]"]; len(files) == 1 {
+			attachment = files[0].Filename
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	content := strings.Repeat("x", discordMaxContentChars+1)
+	deliveries := normalizeDelivery(&delivery{WebhookName: "team", MessageID: "1", Message: DiscordMessage{Content: content}})
+	if err := sendDelivery(testWebhookConfig(t, map[string]string{"team": srv.URL}), deliveries[0]); err != nil {
+		t.Fatalf("sendDelivery: %v", err)
+	}
+	if method != http.MethodPatch || attachment != contentFileName {
+		t.Errorf("got %s with attachment %q, want PATCH with %s", method, attachment, contentFileName)
+	}
+}
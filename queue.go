@@ -0,0 +1,497 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	queueSegmentPrefix = "segment-"
+	queueSegmentSuffix = ".log"
+
+	queueOpEnqueue = "enqueue"
+	queueOpAck     = "ack"
+)
+
+// delivery is one rendered Discord message bound for a single webhook.
+type delivery struct {
+	ID uint64 `json:"id"`
+	// WebhookName is the configured name of the webhook. Its URL is
+	// looked up at every attempt, so a reloaded URL takes effect and
+	// webhook tokens are not written to the queue.
+	WebhookName string         `json:"webhook_name"`
+	Message     DiscordMessage `json:"message"`
+	// Files are attached to the message, e.g. content too long to post.
+	Files []deliveryFile `json:"files,omitempty"`
+	// Fingerprints lists the alert shown in each embed, when the message
+	// should be remembered so it can be edited once the alerts resolve.
+	Fingerprints []string `json:"fingerprints,omitempty"`
+	// MessageID is set when the delivery edits an earlier message instead
+	// of posting a new one.
+	MessageID string `json:"message_id,omitempty"`
+	// ThreadID is the thread containing MessageID, if any.
+	ThreadID string `json:"thread_id,omitempty"`
+	// GroupKey is the Alertmanager group whose thread the message is
+	// posted to, when threads are enabled. The first message of a group
+	// starts the thread, named ThreadName.
+	GroupKey   string `json:"group_key,omitempty"`
+	ThreadName string `json:"thread_name,omitempty"`
+	// CloseThread forgets the group's thread once the message is delivered.
+	CloseThread bool      `json:"close_thread,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+
+	attempts int
+}
+
+// queueRecord is one line of a segment file. Enqueue records carry the
+// delivery, ack records only its ID.
+type queueRecord struct {
+	Op       string    `json:"op"`
+	ID       uint64    `json:"id"`
+	Delivery *delivery `json:"delivery,omitempty"`
+}
+
+// segmentFile is the active segment file; tests replace it to simulate
+// failing writes.
+type segmentFile interface {
+	io.Writer
+	Sync() error
+	Truncate(size int64) error
+	Close() error
+}
+
+type queueSegment struct {
+	seq  uint64
+	path string
+	// live counts enqueued deliveries in this segment not yet acked.
+	live int
+}
+
+// deliveryQueue is a write-ahead log of pending Discord deliveries.
+//
+// Deliveries are appended to the active segment file and synced before
+// enqueue returns. Acks are appended as separate records, and a segment is
+// deleted once it and every older segment are fully acked, so an ack can
+// never outlive the enqueue record it refers to. On open, all segments are
+// replayed and unacked deliveries are scheduled again.
+type deliveryQueue struct {
+	cfg  QueueConfig
+	send func(*delivery) error
+
+	mu         sync.Mutex
+	segments   []*queueSegment
+	active     segmentFile
+	activeSize int64
+	nextID     uint64
+	owner      map[uint64]*queueSegment
+	ready      []*delivery
+	// sending counts deliveries taken by a worker and not yet done with.
+	sending int
+
+	wake chan struct{}
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// deliveryError is returned when Discord answers with a non-2xx status.
+type deliveryError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *deliveryError) Error() string {
+	return fmt.Sprintf("discord returned status %d: %s", e.StatusCode, e.Body)
+}
+
+// errUnknownWebhook is returned for a delivery to a webhook that is no
+// longer configured, e.g. because a reload removed it.
+var errUnknownWebhook = errors.New("webhook is not configured")
+
+// isPermanentDeliveryError reports whether retrying err cannot succeed,
+// i.e. Discord rejected the request itself rather than being unavailable,
+// or the webhook is gone.
+func isPermanentDeliveryError(err error) bool {
+	if errors.Is(err, errUnknownWebhook) {
+		return true
+	}
+	var de *deliveryError
+	if !errors.As(err, &de) {
+		return false
+	}
+	return de.StatusCode >= 400 && de.StatusCode < 500 &&
+		de.StatusCode != 408 && de.StatusCode != 429
+}
+
+// isNotFoundDeliveryError reports whether Discord answered 404, e.g. because
+// the message to edit was deleted.
+func isNotFoundDeliveryError(err error) bool {
+	var de *deliveryError
+	return errors.As(err, &de) && de.StatusCode == 404
+}
+
+// openDeliveryQueue opens or creates the queue in cfg.Directory and replays
+// any deliveries left over from a previous run.
+func openDeliveryQueue(cfg QueueConfig) (*deliveryQueue, error) {
+	if err := os.MkdirAll(cfg.Directory, 0o700); err != nil {
+		return nil, fmt.Errorf("creating queue directory: %w", err)
+	}
+
+	q := &deliveryQueue{
+		cfg:    cfg,
+		nextID: 1,
+		owner:  make(map[uint64]*queueSegment),
+		wake:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+	}
+	if err := q.replay(); err != nil {
+		return nil, err
+	}
+
+	var next uint64 = 1
+	if n := len(q.segments); n > 0 {
+		next = q.segments[n-1].seq + 1
+	}
+	if err := q.openSegment(next); err != nil {
+		return nil, err
+	}
+	q.pruneSegments()
+
+	if len(q.ready) > 0 {
+		slog.Info("Recovered undelivered messages", "messages", len(q.ready), "directory", cfg.Directory)
+	}
+	return q, nil
+}
+
+func (q *deliveryQueue) replay() error {
+	paths, err := filepath.Glob(filepath.Join(q.cfg.Directory, queueSegmentPrefix+"*"+queueSegmentSuffix))
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		var seq uint64
+		name := filepath.Base(path)
+		if _, err := fmt.Sscanf(strings.TrimSuffix(name, queueSegmentSuffix), queueSegmentPrefix+"%d", &seq); err != nil {
+			slog.Warn("Ignoring unexpected file in queue directory", "file", name)
+			continue
+		}
+		q.segments = append(q.segments, &queueSegment{seq: seq, path: path})
+	}
+	sort.Slice(q.segments, func(i, j int) bool { return q.segments[i].seq < q.segments[j].seq })
+
+	pending := make(map[uint64]*delivery)
+	for _, segment := range q.segments {
+		if err := q.replaySegment(segment, pending); err != nil {
+			return err
+		}
+	}
+
+	for _, d := range pending {
+		q.ready = append(q.ready, d)
+	}
+	sort.Slice(q.ready, func(i, j int) bool { return q.ready[i].ID < q.ready[j].ID })
+	return nil
+}
+
+func (q *deliveryQueue) replaySegment(segment *queueSegment, pending map[uint64]*delivery) error {
+	f, err := os.Open(segment.path)
+	if err != nil {
+		return fmt.Errorf("opening queue segment: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var record queueRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			// A crash can tear the last record written, whose enqueue
+			// was never acknowledged. Failed writes are truncated away
+			// by appendRecord, so they cannot tear records after them.
+			slog.Warn("Skipping corrupt queue record", "segment", segment.path, "line", line, "error", err)
+			continue
+		}
+		if record.ID >= q.nextID {
+			q.nextID = record.ID + 1
+		}
+
+		switch record.Op {
+		case queueOpEnqueue:
+			if record.Delivery == nil {
+				continue
+			}
+			pending[record.ID] = record.Delivery
+			q.owner[record.ID] = segment
+			segment.live++
+		case queueOpAck:
+			if owner, ok := q.owner[record.ID]; ok {
+				owner.live--
+				delete(q.owner, record.ID)
+			}
+			delete(pending, record.ID)
+		}
+	}
+	return scanner.Err()
+}
+
+func (q *deliveryQueue) openSegment(seq uint64) error {
+	path := filepath.Join(q.cfg.Directory, fmt.Sprintf("%s%020d%s", queueSegmentPrefix, seq, queueSegmentSuffix))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("creating queue segment: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	if q.active != nil {
+		q.active.Close()
+	}
+	q.active = f
+	q.activeSize = info.Size()
+	q.segments = append(q.segments, &queueSegment{seq: seq, path: path})
+	return nil
+}
+
+// appendRecord writes record to the active segment. Callers hold q.mu.
+// A failed write is truncated away, or the segment is rotated when that
+// fails too, so the next record does not run into its partial line.
+func (q *deliveryQueue) appendRecord(record queueRecord, sync bool) error {
+	if q.activeSize >= q.cfg.SegmentSize {
+		if err := q.openSegment(q.segments[len(q.segments)-1].seq + 1); err != nil {
+			return err
+		}
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if _, err := q.active.Write(line); err != nil {
+		if truncErr := q.active.Truncate(q.activeSize); truncErr != nil {
+			slog.Error("Failed to truncate queue segment after a failed write, starting a new one", "error", truncErr)
+			if rotateErr := q.openSegment(q.segments[len(q.segments)-1].seq + 1); rotateErr != nil {
+				slog.Error("Failed to start a new queue segment", "error", rotateErr)
+				// Try again before the next record is written.
+				q.activeSize = q.cfg.SegmentSize
+			}
+		}
+		return err
+	}
+	q.activeSize += int64(len(line))
+	if sync {
+		return q.active.Sync()
+	}
+	return nil
+}
+
+// pruneSegments deletes fully acked segments from the front of the log.
+// Callers hold q.mu.
+func (q *deliveryQueue) pruneSegments() {
+	for len(q.segments) > 1 && q.segments[0].live <= 0 {
+		if err := os.Remove(q.segments[0].path); err != nil && !os.IsNotExist(err) {
+			slog.Error("Failed to remove queue segment", "segment", q.segments[0].path, "error", err)
+			return
+		}
+		q.segments = q.segments[1:]
+	}
+}
+
+// enqueue persists d and schedules it for delivery. Once it returns nil the
+// message will be delivered even if the process restarts. d must not be
+// used by the caller afterwards.
+func (q *deliveryQueue) enqueue(d *delivery) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	d.ID = q.nextID
+	if err := q.appendRecord(queueRecord{Op: queueOpEnqueue, ID: d.ID, Delivery: d}, true); err != nil {
+		return fmt.Errorf("writing to delivery queue: %w", err)
+	}
+	q.nextID++
+
+	segment := q.segments[len(q.segments)-1]
+	segment.live++
+	q.owner[d.ID] = segment
+
+	q.ready = append(q.ready, d)
+	q.signal()
+	return nil
+}
+
+func (q *deliveryQueue) ack(d *delivery) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err := q.appendRecord(queueRecord{Op: queueOpAck, ID: d.ID}, false); err != nil {
+		// The delivery will be sent again after a restart.
+		slog.Error("Failed to record delivery as done", "id", d.ID, "webhook", d.WebhookName, "error", err)
+		return
+	}
+	if owner, ok := q.owner[d.ID]; ok {
+		owner.live--
+		delete(q.owner, d.ID)
+	}
+	q.pruneSegments()
+}
+
+// depth returns the number of deliveries that are not yet acked.
+func (q *deliveryQueue) depth() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.owner)
+}
+
+// signal wakes one idle worker. Callers hold q.mu.
+func (q *deliveryQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next blocks until a delivery is ready or the queue is closed.
+func (q *deliveryQueue) next() *delivery {
+	for {
+		q.mu.Lock()
+		if len(q.ready) > 0 {
+			d := q.ready[0]
+			q.ready = q.ready[1:]
+			q.sending++
+			if len(q.ready) > 0 {
+				q.signal()
+			}
+			q.mu.Unlock()
+			return d
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-q.wake:
+		case <-q.stop:
+			return nil
+		}
+	}
+}
+
+func (q *deliveryQueue) requeue(d *delivery) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.ready = append(q.ready, d)
+	q.signal()
+}
+
+// backoff returns the delay before the next attempt of d.
+func (q *deliveryQueue) backoff(d *delivery) time.Duration {
+	delay := q.cfg.InitialBackoff
+	for i := 1; i < d.attempts && delay < q.cfg.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > q.cfg.MaxBackoff {
+		delay = q.cfg.MaxBackoff
+	}
+	return delay
+}
+
+// start launches the delivery workers. send performs one delivery attempt.
+func (q *deliveryQueue) start(send func(*delivery) error) {
+	q.send = send
+	for i := 0; i < q.cfg.Workers; i++ {
+		q.wg.Add(1)
+		go q.worker()
+	}
+}
+
+func (q *deliveryQueue) worker() {
+	defer q.wg.Done()
+
+	for {
+		d := q.next()
+		if d == nil {
+			return
+		}
+
+		d.attempts++
+		err := q.send(d)
+		q.handled(d, err)
+
+		q.mu.Lock()
+		q.sending--
+		q.mu.Unlock()
+	}
+}
+
+// handled acks d or schedules its retry after an attempt that returned err.
+func (q *deliveryQueue) handled(d *delivery, err error) {
+	switch {
+	case err == nil:
+		q.ack(d)
+	case isPermanentDeliveryError(err):
+		slog.Error("Dropping message that cannot be delivered", "id", d.ID, "webhook", d.WebhookName, "error", err)
+		q.ack(d)
+	case q.cfg.MaxAge > 0 && time.Since(d.CreatedAt) > q.cfg.MaxAge:
+		slog.Error("Dropping message, retries exhausted",
+			"id", d.ID, "webhook", d.WebhookName, "attempts", d.attempts, "max_age", q.cfg.MaxAge, "error", err)
+		q.ack(d)
+	default:
+		delay := q.backoff(d)
+		slog.Warn("Delivery failed, retrying", "id", d.ID, "webhook", d.WebhookName, "attempt", d.attempts, "retry_in", delay, "error", err)
+		time.AfterFunc(delay, func() { q.requeue(d) })
+	}
+}
+
+// drain waits until no delivery is ready or being sent, or ctx is done.
+// Deliveries waiting to be retried are not waited for. It reports whether
+// the queue became idle.
+func (q *deliveryQueue) drain(ctx context.Context) bool {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		q.mu.Lock()
+		idle := len(q.ready) == 0 && q.sending == 0
+		q.mu.Unlock()
+		if idle {
+			return true
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// close stops the workers and closes the active segment, waiting for
+// deliveries being sent until ctx is done. Deliveries that have not been
+// acked stay on disk for the next start.
+func (q *deliveryQueue) close(ctx context.Context) error {
+	close(q.stop)
+	stopped := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.active.Close()
+}
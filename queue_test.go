@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testQueueConfig(t *testing.T) QueueConfig {
+	t.Helper()
+	return QueueConfig{
+		Directory:      t.TempDir(),
+		Workers:        1,
+		SegmentSize:    8 << 20,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     10 * time.Millisecond,
+	}
+}
+
+func openTestQueue(t *testing.T, cfg QueueConfig) *deliveryQueue {
+	t.Helper()
+	q, err := openDeliveryQueue(cfg)
+	if err != nil {
+		t.Fatalf("openDeliveryQueue: %v", err)
+	}
+	return q
+}
+
+func enqueueTest(t *testing.T, q *deliveryQueue, webhook string) *delivery {
+	t.Helper()
+	d := &delivery{WebhookName: webhook, Message: DiscordMessage{Content: webhook}, CreatedAt: time.Now()}
+	if err := q.enqueue(d); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	return d
+}
+
+func readyIDs(q *deliveryQueue) []uint64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var ids []uint64
+	for _, d := range q.ready {
+		ids = append(ids, d.ID)
+	}
+	return ids
+}
+
+func segmentFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, queueSegmentPrefix+"*"+queueSegmentSuffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func equalIDs(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestDeliveryQueueReplaysUnackedAfterCrash(t *testing.T) {
+	cfg := testQueueConfig(t)
+	q := openTestQueue(t, cfg)
+	first := enqueueTest(t, q, "a")
+	second := enqueueTest(t, q, "b")
+	enqueueTest(t, q, "c")
+	q.ack(first)
+	q.ack(second)
+	// The queue is abandoned without close, as in a crash.
+
+	reopened := openTestQueue(t, cfg)
+	if got := readyIDs(reopened); !equalIDs(got, []uint64{3}) {
+		t.Fatalf("replayed deliveries = %v, want [3]", got)
+	}
+	if got := reopened.ready[0].WebhookName; got != "c" {
+		t.Errorf("replayed delivery is for webhook %q, want c", got)
+	}
+	if got := reopened.depth(); got != 1 {
+		t.Errorf("depth = %d, want 1", got)
+	}
+	if d := enqueueTest(t, reopened, "d"); d.ID != 4 {
+		t.Errorf("new delivery got ID %d after replay, want 4", d.ID)
+	}
+}
+
+func TestDeliveryQueueDeliversEachMessageOnce(t *testing.T) {
+	cfg := testQueueConfig(t)
+	q := openTestQueue(t, cfg)
+
+	var mu sync.Mutex
+	sent := make(map[uint64]int)
+	failedOnce := false
+	q.start(func(d *delivery) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if d.ID == 2 && !failedOnce {
+			failedOnce = true
+			return &deliveryError{StatusCode: 500}
+		}
+		sent[d.ID]++
+		return nil
+	})
+	for _, webhook := range []string{"a", "b", "c"} {
+		enqueueTest(t, q, webhook)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for q.depth() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("deliveries still pending: %d", q.depth())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := q.close(context.Background()); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for id := uint64(1); id <= 3; id++ {
+		if sent[id] != 1 {
+			t.Errorf("delivery %d sent %d times, want 1", id, sent[id])
+		}
+	}
+	if got := readyIDs(openTestQueue(t, cfg)); len(got) != 0 {
+		t.Errorf("acked deliveries came back after reopening: %v", got)
+	}
+}
+
+func TestDeliveryQueueSkipsTornLastRecord(t *testing.T) {
+	cfg := testQueueConfig(t)
+	q := openTestQueue(t, cfg)
+	enqueueTest(t, q, "a")
+	enqueueTest(t, q, "b")
+
+	files := segmentFiles(t, cfg.Directory)
+	if len(files) != 1 {
+		t.Fatalf("segment files = %v, want one", files)
+	}
+	f, err := os.OpenFile(files[0], os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A write cut short by a crash: no closing braces and no newline.
+	if _, err := f.WriteString(`{"op":"enqueue","id":3,"delivery":{"id":3,"webhook_na`); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	reopened := openTestQueue(t, cfg)
+	if got := readyIDs(reopened); !equalIDs(got, []uint64{1, 2}) {
+		t.Fatalf("replayed deliveries = %v, want [1 2]", got)
+	}
+}
+
+func TestDeliveryQueueDeletesAckedSegments(t *testing.T) {
+	cfg := testQueueConfig(t)
+	// Every record goes into a segment of its own.
+	cfg.SegmentSize = 1
+	q := openTestQueue(t, cfg)
+	var pending []*delivery
+	for _, webhook := range []string{"a", "b", "c"} {
+		pending = append(pending, enqueueTest(t, q, webhook))
+	}
+	if got := len(segmentFiles(t, cfg.Directory)); got != 3 {
+		t.Fatalf("segment files after enqueue = %d, want 3", got)
+	}
+
+	q.ack(pending[1])
+	// The first segment still holds an unacked delivery, so nothing after
+	// it may be deleted either.
+	if got := len(segmentFiles(t, cfg.Directory)); got != 4 {
+		t.Errorf("segment files after acking the second delivery = %d, want 4", got)
+	}
+
+	q.ack(pending[0])
+	q.ack(pending[2])
+	if got := segmentFiles(t, cfg.Directory); len(got) != 1 {
+		t.Errorf("segment files after acking everything = %v, want only the active one", got)
+	}
+	if got := readyIDs(openTestQueue(t, cfg)); len(got) != 0 {
+		t.Errorf("replayed deliveries = %v, want none", got)
+	}
+}
+
+// failingSegment writes only the first half of the next line, then fails.
+type failingSegment struct {
+	*os.File
+	fail bool
+}
+
+func (f *failingSegment) Write(p []byte) (int, error) {
+	if !f.fail {
+		return f.File.Write(p)
+	}
+	f.fail = false
+	n, _ := f.File.Write(p[:len(p)/2])
+	return n, errors.New("disk full")
+}
+
+func TestDeliveryQueueRecoversFromFailedWrite(t *testing.T) {
+	cfg := testQueueConfig(t)
+	q := openTestQueue(t, cfg)
+	enqueueTest(t, q, "a")
+
+	failing := &failingSegment{File: q.active.(*os.File), fail: true}
+	q.active = failing
+	if err := q.enqueue(&delivery{WebhookName: "b", CreatedAt: time.Now()}); err == nil {
+		t.Fatal("enqueue succeeded although the write failed")
+	}
+	// Enqueues that succeeded after the failed one must survive.
+	third := enqueueTest(t, q, "c")
+	fourth := enqueueTest(t, q, "d")
+	q.ack(fourth)
+
+	reopened := openTestQueue(t, cfg)
+	if got := readyIDs(reopened); !equalIDs(got, []uint64{1, third.ID}) {
+		t.Fatalf("replayed deliveries = %v, want [1 %d]", got, third.ID)
+	}
+	for _, d := range reopened.ready {
+		if d.WebhookName == "b" {
+			t.Error("the failed enqueue was replayed")
+		}
+	}
+}
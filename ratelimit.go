@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimiter keeps one Discord rate limit bucket per webhook URL, plus
+// Discord's global limit, and spaces requests so they are not rejected.
+type rateLimiter struct {
+	mu          sync.Mutex
+	buckets     map[string]*rateBucket
+	globalUntil time.Time
+}
+
+type rateBucket struct {
+	// remaining is the number of requests left until resetAt, or -1 when
+	// Discord has not told us yet.
+	remaining int
+	resetAt   time.Time
+	lastSent  time.Time
+}
+
+// discordRateLimitResponse is the JSON body Discord sends with a 429.
+type discordRateLimitResponse struct {
+	Message    string  `json:"message"`
+	RetryAfter float64 `json:"retry_after"`
+	Global     bool    `json:"global"`
+}
+
+var discordRateLimiter = newRateLimiter()
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: make(map[string]*rateBucket)}
+}
+
+// rateLimitKey identifies the bucket of a webhook URL. Query parameters such
+// as wait or thread_id do not change the bucket.
+func rateLimitKey(webhook string) string {
+	if i := strings.IndexByte(webhook, '?'); i >= 0 {
+		return webhook[:i]
+	}
+	return webhook
+}
+
+func (l *rateLimiter) bucket(key string) *rateBucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &rateBucket{remaining: -1}
+		l.buckets[key] = b
+	}
+	return b
+}
+
+// reserve claims a request slot in the bucket for key. It returns zero when
+// the request may be sent now, or how long to wait before asking again.
+func (l *rateLimiter) reserve(key string, minInterval time.Duration) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	b := l.bucket(key)
+
+	notBefore := l.globalUntil
+	if b.remaining == 0 && b.resetAt.After(notBefore) {
+		notBefore = b.resetAt
+	}
+	if next := b.lastSent.Add(minInterval); next.After(notBefore) {
+		notBefore = next
+	}
+	if wait := notBefore.Sub(now); wait > 0 {
+		return wait
+	}
+
+	if b.remaining == 0 {
+		// The window has passed; Discord will tell us the new budget.
+		b.remaining = -1
+	}
+	if b.remaining > 0 {
+		b.remaining--
+	}
+	b.lastSent = now
+	return 0
+}
+
+// wait blocks until a request to webhook may be sent.
+func (l *rateLimiter) wait(webhook string, minInterval time.Duration) {
+	key := rateLimitKey(webhook)
+	for {
+		delay := l.reserve(key, minInterval)
+		if delay <= 0 {
+			return
+		}
+		time.Sleep(delay)
+	}
+}
+
+// update records the X-RateLimit-* headers of a Discord response.
+func (l *rateLimiter) update(webhook string, header http.Header) {
+	remaining, errRemaining := strconv.Atoi(header.Get("X-RateLimit-Remaining"))
+	resetAfter, okReset := parseSeconds(header.Get("X-RateLimit-Reset-After"))
+	if errRemaining != nil && !okReset {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucket(rateLimitKey(webhook))
+	if errRemaining == nil {
+		b.remaining = remaining
+	}
+	if okReset {
+		b.resetAt = time.Now().Add(resetAfter)
+	}
+}
+
+// limited records a 429 response and returns how long Discord asked us to
+// wait before retrying and whether the global limit was hit.
+func (l *rateLimiter) limited(webhook string, header http.Header, body []byte) (time.Duration, bool) {
+	var payload discordRateLimitResponse
+	_ = json.Unmarshal(body, &payload)
+
+	retryAfter, ok := parseSeconds(header.Get("Retry-After"))
+	if bodyRetry := secondsToDuration(payload.RetryAfter); bodyRetry > retryAfter {
+		// Honor whichever of the header and the body asks for longer.
+		retryAfter, ok = bodyRetry, true
+	}
+	if !ok {
+		retryAfter, ok = parseSeconds(header.Get("X-RateLimit-Reset-After"))
+	}
+	if !ok || retryAfter <= 0 {
+		retryAfter = time.Second
+	}
+
+	global := payload.Global || strings.EqualFold(header.Get("X-RateLimit-Global"), "true")
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	until := time.Now().Add(retryAfter)
+	if global {
+		if until.After(l.globalUntil) {
+			l.globalUntil = until
+		}
+	} else {
+		b := l.bucket(rateLimitKey(webhook))
+		b.remaining = 0
+		b.resetAt = until
+	}
+	return retryAfter, global
+}
+
+// parseSeconds parses a decimal number of seconds as sent in Discord's
+// Retry-After and X-RateLimit-Reset-After headers.
+func parseSeconds(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return secondsToDuration(seconds), true
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(math.Ceil(seconds * float64(time.Second)))
+}
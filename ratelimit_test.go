@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseSeconds(t *testing.T) {
+	for _, tc := range []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"", 0, false},
+		{"2", 2 * time.Second, true},
+		{"0.25", 250 * time.Millisecond, true},
+		{"1.0001", 1000100 * time.Microsecond, true},
+		{"0", 0, true},
+		{"-1", 0, false},
+		{"soon", 0, false},
+	} {
+		got, ok := parseSeconds(tc.value)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("parseSeconds(%q) = %v, %v; want %v, %v", tc.value, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestRateLimitKey(t *testing.T) {
+	for _, tc := range []struct{ webhook, want string }{
+		{"https://discord.com/api/webhooks/1/a", "https://discord.com/api/webhooks/1/a"},
+		{"https://discord.com/api/webhooks/1/a?wait=true", "https://discord.com/api/webhooks/1/a"},
+		{"https://discord.com/api/webhooks/1/a?thread_id=2&wait=true", "https://discord.com/api/webhooks/1/a"},
+	} {
+		if got := rateLimitKey(tc.webhook); got != tc.want {
+			t.Errorf("rateLimitKey(%q) = %q, want %q", tc.webhook, got, tc.want)
+		}
+	}
+}
+
+func TestRateLimiterLimited(t *testing.T) {
+	const webhook, other = "https://discord.com/api/webhooks/1/a", "https://discord.com/api/webhooks/2/b"
+	for _, tc := range []struct {
+		name       string
+		header     http.Header
+		body       string
+		retryAfter time.Duration
+		global     bool
+	}{
+		{"Retry-After header", http.Header{"Retry-After": {"3"}}, "", 3 * time.Second, false},
+		{"body asks for longer", http.Header{"Retry-After": {"1"}}, `{"retry_after": 2.5}`, 2500 * time.Millisecond, false},
+		{"header asks for longer", http.Header{"Retry-After": {"4"}}, `{"retry_after": 2.5}`, 4 * time.Second, false},
+		{"reset after as fallback", http.Header{"X-Ratelimit-Reset-After": {"1.5"}}, "", 1500 * time.Millisecond, false},
+		{"nothing to go by", http.Header{}, "not json", time.Second, false},
+		{"global in body", http.Header{"Retry-After": {"2"}}, `{"global": true}`, 2 * time.Second, true},
+		{"global header", http.Header{"Retry-After": {"2"}, "X-Ratelimit-Global": {"true"}}, "", 2 * time.Second, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			l := newRateLimiter()
+			retryAfter, global := l.limited(webhook+"?wait=true", tc.header, []byte(tc.body))
+			if retryAfter != tc.retryAfter || global != tc.global {
+				t.Fatalf("limited = %v, %v; want %v, %v", retryAfter, global, tc.retryAfter, tc.global)
+			}
+
+			if wait := l.reserve(rateLimitKey(webhook), 0); wait <= 0 || wait > tc.retryAfter {
+				t.Errorf("limited webhook may send again in %v, want within (0, %v]", wait, tc.retryAfter)
+			}
+			// Only the global limit holds back other webhooks.
+			if wait := l.reserve(rateLimitKey(other), 0); (wait > 0) != tc.global {
+				t.Errorf("other webhook may send again in %v, global = %v", wait, tc.global)
+			}
+		})
+	}
+}
+
+func TestRateLimiterBuckets(t *testing.T) {
+	const webhook = "https://discord.com/api/webhooks/1/a"
+	for _, tc := range []struct {
+		name   string
+		header http.Header
+		// sendable is the number of requests allowed before waiting.
+		sendable int
+	}{
+		{"no headers", http.Header{}, 3},
+		{"remaining budget", http.Header{"X-Ratelimit-Remaining": {"2"}, "X-Ratelimit-Reset-After": {"60"}}, 2},
+		{"exhausted bucket", http.Header{"X-Ratelimit-Remaining": {"0"}, "X-Ratelimit-Reset-After": {"60"}}, 0},
+		{"exhausted bucket that already reset", http.Header{"X-Ratelimit-Remaining": {"0"}, "X-Ratelimit-Reset-After": {"0"}}, 3},
+		{"malformed headers", http.Header{"X-Ratelimit-Remaining": {"many"}, "X-Ratelimit-Reset-After": {"later"}}, 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			l := newRateLimiter()
+			l.update(webhook, tc.header)
+
+			sent := 0
+			for sent < 3 && l.reserve(rateLimitKey(webhook), 0) == 0 {
+				sent++
+			}
+			if sent != tc.sendable {
+				t.Errorf("sent %d requests before waiting, want %d", sent, tc.sendable)
+			}
+		})
+	}
+}
+
+func TestRateLimiterMinInterval(t *testing.T) {
+	l := newRateLimiter()
+	if wait := l.reserve("a", time.Minute); wait != 0 {
+		t.Fatalf("first request waits %v", wait)
+	}
+	if wait := l.reserve("a", time.Minute); wait <= 0 || wait > time.Minute {
+		t.Errorf("second request waits %v, want up to the minimum interval", wait)
+	}
+	if wait := l.reserve("b", time.Minute); wait != 0 {
+		t.Errorf("request to another webhook waits %v", wait)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// reloadPath re-reads the configuration, like Alertmanager's endpoint.
+const reloadPath = "/-/reload"
+
+// configWatchDelay lets a file settle after a change before it is read, so
+// an editor writing it in several steps causes a single reload.
+const configWatchDelay = 500 * time.Millisecond
+
+// reloadMu serializes reloads from signals, the file watch and the endpoint.
+var reloadMu sync.Mutex
+
+// reloadConfig re-reads the configuration and swaps it in as a whole. When
+// it cannot be loaded the current configuration stays in effect.
+func reloadConfig(trigger string) error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	next, err := loadConfig(*configFile)
+	if err == nil && next.Discord.WebhookURL == "" {
+		err = fmt.Errorf("invalid configuration: discord.webhook_url is required")
+	}
+	if err == nil {
+		if _, urlErr := validWebhookURL(next.Discord.WebhookURL); urlErr != nil {
+			err = fmt.Errorf("invalid configuration: discord.webhook_url is not a valid URL")
+		}
+	}
+	if err != nil {
+		configReloadSuccess.Set(0)
+		slog.Error("Failed to reload configuration, keeping the current one", "trigger", trigger, "error", err)
+		return err
+	}
+
+	// These settings are only read at startup.
+	current := config()
+	keepUntilRestart("server", current.Server, &next.Server)
+	keepUntilRestart("queue", current.Queue, &next.Queue)
+	keepUntilRestart("state", current.State, &next.State)
+	keepUntilRestart("logging", current.Logging, &next.Logging)
+	keepUntilRestart("escalation.interval", current.Escalation.Interval, &next.Escalation.Interval)
+
+	currentConfig.Store(next)
+	// Requests still running finish on the old client; its idle
+	// connections would otherwise stay open until they time out.
+	if current.httpClient != nil {
+		current.httpClient.CloseIdleConnections()
+	}
+	configReloadSuccess.Set(1)
+	configReloadTime.SetToCurrentTime()
+	slog.Info("Reloaded configuration", "trigger", trigger, "file", *configFile)
+	return nil
+}
+
+// keepUntilRestart sets *next back to current, warning if they differ.
+func keepUntilRestart[T any](name string, current T, next *T) {
+	if !reflect.DeepEqual(current, *next) {
+		slog.Warn("Configuration change needs a restart to take effect", "setting", name)
+		*next = current
+	}
+}
+
+// handleReload reloads the configuration on POST and answers 500 with the
+// error if it is rejected.
+func handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := reloadConfig("http"); err != nil {
+		http.Error(w, fmt.Sprintf("failed to reload config: %s", err), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// reloadOnSignal reloads the configuration on every SIGHUP.
+func reloadOnSignal() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			reloadConfig("signal")
+		}
+	}()
+}
+
+// watchConfigFile reloads the configuration when the content of path
+// changes. The directory is watched rather than the file, so files that are
+// replaced, e.g. by editors or Kubernetes ConfigMap updates, are followed.
+func watchConfigFile(path string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	last := fileDigest(path)
+	changed := make(chan struct{}, 1)
+	go func() {
+		var timer *time.Timer
+		for {
+			select {
+			case _, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if timer != nil {
+					timer.Stop()
+				}
+				timer = time.AfterFunc(configWatchDelay, func() {
+					select {
+					case changed <- struct{}{}:
+					default:
+					}
+				})
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				slog.Warn("Error watching configuration file", "file", path, "error", err)
+			}
+		}
+	}()
+	go func() {
+		for range changed {
+			// Other files in the directory change too; only reload
+			// when the content of the configuration file did.
+			digest := fileDigest(path)
+			if digest == nil || bytes.Equal(digest, last) {
+				continue
+			}
+			last = digest
+			reloadConfig("file")
+		}
+	}()
+	return nil
+}
+
+// fileDigest returns the SHA-256 of the content of path, or nil if it
+// cannot be read.
+func fileDigest(path string) []byte {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	digest := sha256.Sum256(raw)
+	return digest[:]
+}
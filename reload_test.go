@@ -0,0 +1,136 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testWebhookURL = "https://discord.com/api/webhooks/123456789012345678/token"
+
+// withConfigFile points -config at a file with content and loads it.
+func withConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yml")
+	writeConfigFile(t, path, content)
+	previous := *configFile
+	*configFile = path
+	t.Cleanup(func() { *configFile = previous })
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	withConfig(t, cfg)
+	return path
+}
+
+func writeConfigFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	path := withConfigFile(t, "discord:\n  webhook_url: "+testWebhookURL+"\n  username: before\n")
+
+	writeConfigFile(t, path, "discord:\n  webhook_url: "+testWebhookURL+"\n  username: after\n")
+	if err := reloadConfig("test"); err != nil {
+		t.Fatalf("reloadConfig: %v", err)
+	}
+	if got := config().Discord.Username; got != "after" {
+		t.Errorf("username after reload = %q, want after", got)
+	}
+}
+
+func TestReloadConfigRejectsBadWebhookURLs(t *testing.T) {
+	for name, content := range map[string]string{
+		"main webhook":       "discord:\n  webhook_url: \"http://%zz\"\n",
+		"named webhook":      "discord:\n  webhook_url: " + testWebhookURL + "\n  webhooks:\n    team: \"http://%zz\"\n",
+		"additional webhook": "discord:\n  webhook_url: " + testWebhookURL + "\n  additional_webhooks: \"" + testWebhookURL + ",http://%zz\"\n",
+		"no webhook":         "discord:\n  username: nobody\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := withConfigFile(t, "discord:\n  webhook_url: "+testWebhookURL+"\n")
+			before := config()
+
+			writeConfigFile(t, path, content)
+			if err := reloadConfig("test"); err == nil {
+				t.Fatal("reloadConfig accepted the configuration")
+			}
+			if config() != before {
+				t.Error("the rejected configuration replaced the current one")
+			}
+		})
+	}
+}
+
+func TestSnapshotConfigKeepsConfigForRequest(t *testing.T) {
+	before := defaultConfig()
+	withConfig(t, before)
+
+	handler := snapshotConfig(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A reload while the request is handled.
+		currentConfig.Store(defaultConfig())
+		if requestConfig(r) != before {
+			t.Error("request saw the configuration that was loaded after it arrived")
+		}
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+
+	if requestConfig(httptest.NewRequest(http.MethodGet, "/", nil)) != config() {
+		t.Error("requests without a snapshot do not see the current configuration")
+	}
+}
+
+func TestReloadMovesEndpoints(t *testing.T) {
+	path := withConfigFile(t, "discord:\n  webhook_url: "+testWebhookURL+"\nmetrics:\n  enabled: true\n")
+	handler := snapshotConfig(newServeMux())
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+	if w := get("/metrics"); w.Code != http.StatusOK {
+		t.Fatalf("GET /metrics before reload: %d", w.Code)
+	}
+
+	writeConfigFile(t, path, "discord:\n  webhook_url: "+testWebhookURL+"\nhealth:\n  enabled: false\nmetrics:\n  enabled: true\n  endpoint: /prometheus\n")
+	if err := reloadConfig("test"); err != nil {
+		t.Fatalf("reloadConfig: %v", err)
+	}
+	if w := get("/prometheus"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "go_goroutines") {
+		t.Errorf("GET /prometheus after reload: %d, want metrics", w.Code)
+	}
+	for _, path := range []string{"/metrics", "/healthz"} {
+		if w := get(path); w.Code == http.StatusOK {
+			t.Errorf("GET %s after reload: %d %s, want it disabled", path, w.Code, w.Body)
+		}
+	}
+}
+
+// idleCounter is a transport that counts calls to CloseIdleConnections.
+type idleCounter struct {
+	http.RoundTripper
+	closed int
+}
+
+func (c *idleCounter) CloseIdleConnections() { c.closed++ }
+
+func TestReloadClosesIdleConnections(t *testing.T) {
+	path := withConfigFile(t, "discord:\n  webhook_url: "+testWebhookURL+"\n")
+	transport := &idleCounter{RoundTripper: http.DefaultTransport}
+	config().httpClient = &http.Client{Transport: transport}
+
+	writeConfigFile(t, path, "discord:\n  webhook_url: "+testWebhookURL+"\n  username: after\n")
+	if err := reloadConfig("test"); err != nil {
+		t.Fatalf("reloadConfig: %v", err)
+	}
+	if transport.closed != 1 {
+		t.Errorf("idle connections of the old client closed %d times, want once", transport.closed)
+	}
+}
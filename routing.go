@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// defaultWebhookName is the name under which discord.webhook_url can be
+// referenced from routes.
+const defaultWebhookName = "default"
+
+type matchType string
+
+const (
+	matchEqual     matchType = "="
+	matchNotEqual  matchType = "!="
+	matchRegexp    matchType = "=~"
+	matchNotRegexp matchType = "!~"
+)
+
+// labelMatcher matches one alert label, using the same operators as
+// Alertmanager's route matchers.
+type labelMatcher struct {
+	Name  string
+	Type  matchType
+	Value string
+
+	re *regexp.Regexp
+}
+
+var labelMatcherRe = regexp.MustCompile(`^\s*([a-zA-Z_][a-zA-Z0-9_]*)\s*(=~|!~|!=|=)\s*(.*?)\s*$`)
+
+// parseLabelMatcher parses a matcher such as `team="gpu"` or
+// `severity=~"critical|page"`. Quoting the value is optional.
+func parseLabelMatcher(s string) (*labelMatcher, error) {
+	parts := labelMatcherRe.FindStringSubmatch(s)
+	if parts == nil {
+		return nil, fmt.Errorf("invalid matcher %q", s)
+	}
+
+	value := parts[3]
+	if strings.HasPrefix(value, `"`) {
+		unquoted, err := strconv.Unquote(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid matcher %q: bad quoting", s)
+		}
+		value = unquoted
+	}
+
+	m := &labelMatcher{Name: parts[1], Type: matchType(parts[2]), Value: value}
+	if m.Type == matchRegexp || m.Type == matchNotRegexp {
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid matcher %q: %w", s, err)
+		}
+		m.re = re
+	}
+	return m, nil
+}
+
+// matches reports whether labels satisfy m. A missing label is treated as
+// the empty string, like Alertmanager does.
+func (m *labelMatcher) matches(labels KV) bool {
+	value := labels[m.Name]
+	switch m.Type {
+	case matchEqual:
+		return value == m.Value
+	case matchNotEqual:
+		return value != m.Value
+	case matchRegexp:
+		return m.re.MatchString(value)
+	case matchNotRegexp:
+		return !m.re.MatchString(value)
+	}
+	return false
+}
+
+func (m *labelMatcher) String() string {
+	return fmt.Sprintf("%s%s%q", m.Name, m.Type, m.Value)
+}
+
+type labelMatchers []*labelMatcher
+
+func parseLabelMatchers(matchers []string) (labelMatchers, error) {
+	parsed := make(labelMatchers, 0, len(matchers))
+	for _, s := range matchers {
+		m, err := parseLabelMatcher(s)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, m)
+	}
+	return parsed, nil
+}
+
+// matches reports whether every matcher matches labels.
+func (ms labelMatchers) matches(labels KV) bool {
+	for _, m := range ms {
+		if !m.matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+type alertRoute struct {
+	matchers labelMatchers
+	webhooks []string
+	cont     bool
+}
+
+// alertRouter picks the Discord webhooks an alert is sent to.
+type alertRouter struct {
+	// webhooks maps webhook names to URLs.
+	webhooks map[string]string
+	routes   []alertRoute
+	defaults []string
+}
+
+// newAlertRouter builds the router from the named webhooks and the routing
+// table. legacy lists the webhooks used when no default is configured.
+func newAlertRouter(webhooks map[string]string, legacy []string, cfg RoutingConfig) (*alertRouter, error) {
+	r := &alertRouter{webhooks: webhooks, defaults: cfg.Default}
+	if len(r.defaults) == 0 {
+		r.defaults = legacy
+	}
+	if err := r.checkNames("routing.default", r.defaults); err != nil {
+		return nil, err
+	}
+
+	for i, rc := range cfg.Routes {
+		where := fmt.Sprintf("routing.routes[%d]", i)
+		if len(rc.Webhooks) == 0 {
+			return nil, fmt.Errorf("%s: no webhooks configured", where)
+		}
+		if err := r.checkNames(where, rc.Webhooks); err != nil {
+			return nil, err
+		}
+		matchers, err := parseLabelMatchers(rc.Matchers)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", where, err)
+		}
+		r.routes = append(r.routes, alertRoute{matchers: matchers, webhooks: rc.Webhooks, cont: rc.Continue})
+	}
+	return r, nil
+}
+
+func (r *alertRouter) checkNames(where string, names []string) error {
+	for _, name := range names {
+		if _, ok := r.webhooks[name]; !ok {
+			return fmt.Errorf("%s: unknown webhook %q", where, name)
+		}
+	}
+	return nil
+}
+
+// route returns the names of the webhooks alert should be sent to. Routes
+// are tried in order; the first match wins unless it sets continue. Alerts
+// that match no route go to the default webhooks.
+func (r *alertRouter) route(alert *AlertManagerAlert) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, rt := range r.routes {
+		if !rt.matchers.matches(alert.Labels) {
+			continue
+		}
+		for _, name := range rt.webhooks {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+		if !rt.cont {
+			break
+		}
+	}
+	if len(names) == 0 {
+		return r.defaults
+	}
+	return names
+}
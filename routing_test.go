@@ -0,0 +1,128 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLabelMatcher(t *testing.T) {
+	labels := KV{"alertname": "HighLoad", "severity": "critical", "instance": "web-1"}
+	for _, tc := range []struct {
+		matcher string
+		want    bool
+	}{
+		{`severity="critical"`, true},
+		{`severity=critical`, true},
+		{` severity = "critical" `, true},
+		{`severity="warning"`, false},
+		{`severity!="warning"`, true},
+		{`severity!="critical"`, false},
+		{`severity=~"critical|page"`, true},
+		{`severity!~"critical|page"`, false},
+		// Regular expressions are anchored at both ends, like Alertmanager's.
+		{`severity=~"crit"`, false},
+		{`severity=~"ical"`, false},
+		{`instance=~"web-.*"`, true},
+		{`instance=~"a|web-1"`, true},
+		{`instance!~"web"`, true},
+		// A missing label is the empty string.
+		{`team=""`, true},
+		{`team!=""`, false},
+		{`team=~".*"`, true},
+		{`team=~".+"`, false},
+		{`alertname="High\"Load"`, false},
+	} {
+		m, err := parseLabelMatcher(tc.matcher)
+		if err != nil {
+			t.Errorf("parseLabelMatcher(%q): %v", tc.matcher, err)
+			continue
+		}
+		if got := m.matches(labels); got != tc.want {
+			t.Errorf("%s matches = %v, want %v", tc.matcher, got, tc.want)
+		}
+	}
+}
+
+func TestParseLabelMatcherErrors(t *testing.T) {
+	for _, matcher := range []string{
+		``,
+		`severity`,
+		`1severity="critical"`,
+		`severity~"critical"`,
+		`severity="critical`,
+		`severity=~"("`,
+	} {
+		if _, err := parseLabelMatcher(matcher); err == nil {
+			t.Errorf("parseLabelMatcher(%q) accepted an invalid matcher", matcher)
+		}
+	}
+}
+
+func TestAlertRouterRoute(t *testing.T) {
+	webhooks := map[string]string{"default": "a", "gpu": "b", "oncall": "c", "db": "d"}
+	router, err := newAlertRouter(webhooks, []string{"default"}, RoutingConfig{Routes: []RouteConfig{
+		{Matchers: []string{`team="gpu"`}, Webhooks: []string{"gpu"}, Continue: true},
+		{Matchers: []string{`severity="critical"`}, Webhooks: []string{"oncall", "gpu"}},
+		{Matchers: []string{`team="db"`}, Webhooks: []string{"db"}},
+		{Matchers: []string{`team=~"db|gpu"`}, Webhooks: []string{"oncall"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		labels KV
+		want   []string
+	}{
+		{"no route matches", KV{"team": "web"}, []string{"default"}},
+		{"first match wins", KV{"team": "db", "severity": "critical"}, []string{"oncall", "gpu"}},
+		{"first match without continue", KV{"team": "db"}, []string{"db"}},
+		{"continue adds the next match once", KV{"team": "gpu", "severity": "critical"}, []string{"gpu", "oncall"}},
+		{"continue skips routes that do not match", KV{"team": "gpu", "severity": "warning"}, []string{"gpu", "oncall"}},
+		{"all matchers must match", KV{"severity": "warning"}, []string{"default"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := router.route(&AlertManagerAlert{Labels: tc.labels}); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("route = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAlertRouterDefaults(t *testing.T) {
+	webhooks := map[string]string{"default": "a", "additional-1": "b", "catchall": "c"}
+	for _, tc := range []struct {
+		name   string
+		legacy []string
+		cfg    RoutingConfig
+		want   []string
+	}{
+		{"legacy webhooks", []string{"default", "additional-1"}, RoutingConfig{}, []string{"default", "additional-1"}},
+		{"configured default", []string{"default"}, RoutingConfig{Default: []string{"catchall"}}, []string{"catchall"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			router, err := newAlertRouter(webhooks, tc.legacy, tc.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := router.route(&AlertManagerAlert{}); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("route = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestNewAlertRouterErrors(t *testing.T) {
+	webhooks := map[string]string{"default": "a"}
+	for name, cfg := range map[string]RoutingConfig{
+		"unknown default":       {Default: []string{"missing"}},
+		"unknown route webhook": {Routes: []RouteConfig{{Matchers: []string{`a="b"`}, Webhooks: []string{"missing"}}}},
+		"route without webhook": {Routes: []RouteConfig{{Matchers: []string{`a="b"`}}}},
+		"bad matcher":           {Routes: []RouteConfig{{Matchers: []string{`a=~"("`}, Webhooks: []string{"default"}}}},
+	} {
+		if _, err := newAlertRouter(webhooks, []string{"default"}, cfg); err == nil {
+			t.Errorf("%s: newAlertRouter accepted the configuration", name)
+		}
+	}
+}
@@ -0,0 +1,598 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	messageStoreFile = "messages.json"
+	threadStoreFile  = "threads.json"
+	alertStoreFile   = "alerts.json"
+)
+
+// alertStoreSaveDelay collects the changes of notifications arriving close
+// together into one write of the alert store.
+const alertStoreSaveDelay = 5 * time.Second
+
+// sentMessage is a Discord message posted by the bridge, remembered so it
+// can be edited when the alerts it shows resolve.
+type sentMessage struct {
+	ID          string `json:"id"`
+	WebhookName string `json:"webhook_name"`
+	// ThreadID is the thread the message was posted in, if any.
+	ThreadID string `json:"thread_id,omitempty"`
+	// Fingerprints holds the fingerprint of the alert shown in each embed,
+	// or "" once that alert has resolved.
+	Fingerprints []string      `json:"fingerprints"`
+	Content      string        `json:"content,omitempty"`
+	Embeds       DiscordEmbeds `json:"embeds"`
+	PostedAt     time.Time     `json:"posted_at"`
+}
+
+// messageStore remembers the messages showing firing alerts. With a state
+// directory it is written to disk after every change, so messages can still
+// be edited after a restart.
+type messageStore struct {
+	mu        sync.Mutex
+	path      string
+	retention time.Duration
+	messages  map[string]*sentMessage
+}
+
+// openMessageStore loads the messages saved in cfg.Directory, if any.
+func openMessageStore(cfg StateConfig) (*messageStore, error) {
+	s := &messageStore{retention: cfg.Retention, messages: make(map[string]*sentMessage)}
+	if cfg.Directory == "" {
+		return s, nil
+	}
+	if err := os.MkdirAll(cfg.Directory, 0o700); err != nil {
+		return nil, fmt.Errorf("creating state directory: %w", err)
+	}
+	s.path = filepath.Join(cfg.Directory, messageStoreFile)
+
+	var messages []*sentMessage
+	if err := readJSONFile(s.path, &messages); err != nil {
+		return nil, err
+	}
+	for _, m := range messages {
+		s.messages[m.ID] = m
+	}
+	s.prune()
+	return s, nil
+}
+
+// record remembers a message that was just posted.
+func (s *messageStore) record(m *sentMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.messages[m.ID] = m
+	s.prune()
+	s.save()
+}
+
+// resolve replaces the embed showing the alert with fingerprint on webhook
+// by embed in every remembered message, and returns copies of the updated
+// messages. Messages whose alerts have all resolved are forgotten.
+func (s *messageStore) resolve(webhookName, fingerprint string, embed DiscordEmbed) []sentMessage {
+	if fingerprint == "" {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var updated []sentMessage
+	for id, m := range s.messages {
+		if m.WebhookName != webhookName {
+			continue
+		}
+		// An alert may span several embeds; the first one is replaced
+		// and its continuations are removed.
+		changed := false
+		pending := 0
+		var embeds DiscordEmbeds
+		var fingerprints []string
+		for i, fp := range m.Fingerprints {
+			if i >= len(m.Embeds) {
+				break
+			}
+			switch {
+			case fp == fingerprint && !changed:
+				embeds = append(embeds, embed)
+				fingerprints = append(fingerprints, "")
+				changed = true
+			case fp == fingerprint:
+			default:
+				embeds = append(embeds, m.Embeds[i])
+				fingerprints = append(fingerprints, fp)
+				if fp != "" {
+					pending++
+				}
+			}
+		}
+		if !changed {
+			continue
+		}
+		m.Embeds, m.Fingerprints = embeds, fingerprints
+
+		copied := *m
+		copied.Embeds = append(DiscordEmbeds(nil), m.Embeds...)
+		updated = append(updated, copied)
+		if pending == 0 {
+			delete(s.messages, id)
+		}
+	}
+	if len(updated) > 0 {
+		s.save()
+	}
+	return updated
+}
+
+// replaceEmbeds replaces the embeds of the remembered message id, as long
+// as it shows as many embeds, so later edits keep changes made in Discord.
+func (s *messageStore) replaceEmbeds(id string, embeds DiscordEmbeds) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.messages[id]
+	if !ok || len(m.Embeds) != len(embeds) {
+		return
+	}
+	m.Embeds = append(DiscordEmbeds(nil), embeds...)
+	s.save()
+}
+
+// prune forgets messages older than the retention.
+func (s *messageStore) prune() {
+	for id, m := range s.messages {
+		if time.Since(m.PostedAt) > s.retention {
+			delete(s.messages, id)
+		}
+	}
+}
+
+func (s *messageStore) save() {
+	if s.path == "" {
+		return
+	}
+	messages := make([]*sentMessage, 0, len(s.messages))
+	for _, m := range s.messages {
+		messages = append(messages, m)
+	}
+	if err := writeJSONFile(s.path, messages); err != nil {
+		slog.Error("Failed to save message IDs", "file", s.path, "error", err)
+	}
+}
+
+// groupThread is the Discord thread of an Alertmanager group.
+type groupThread struct {
+	WebhookName string    `json:"webhook_name"`
+	GroupKey    string    `json:"group_key"`
+	ThreadID    string    `json:"thread_id"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// threadStore maps Alertmanager groups to the Discord threads their
+// notifications are posted in. Like messageStore it is saved to the state
+// directory after every change.
+type threadStore struct {
+	mu        sync.Mutex
+	path      string
+	retention time.Duration
+	threads   map[string]*groupThread
+	// locks holds a lock per group only while deliveries of the group
+	// hold or wait for it.
+	locks map[string]*groupLock
+}
+
+// groupLock serializes the deliveries of one group; refs counts those
+// holding or waiting for it.
+type groupLock struct {
+	sync.Mutex
+	refs int
+}
+
+func threadStoreKey(webhookName, groupKey string) string {
+	return webhookName + "\x00" + groupKey
+}
+
+// openThreadStore loads the threads saved in cfg.Directory, if any.
+func openThreadStore(cfg StateConfig) (*threadStore, error) {
+	s := &threadStore{
+		retention: cfg.Retention,
+		threads:   make(map[string]*groupThread),
+		locks:     make(map[string]*groupLock),
+	}
+	if cfg.Directory == "" {
+		return s, nil
+	}
+	if err := os.MkdirAll(cfg.Directory, 0o700); err != nil {
+		return nil, fmt.Errorf("creating state directory: %w", err)
+	}
+	s.path = filepath.Join(cfg.Directory, threadStoreFile)
+
+	var threads []*groupThread
+	if err := readJSONFile(s.path, &threads); err != nil {
+		return nil, err
+	}
+	for _, t := range threads {
+		s.threads[threadStoreKey(t.WebhookName, t.GroupKey)] = t
+	}
+	return s, nil
+}
+
+// lock serializes the deliveries of one group and returns the unlock func.
+// The group's lock is dropped once nobody holds or waits for it.
+func (s *threadStore) lock(key string) func() {
+	s.mu.Lock()
+	l, ok := s.locks[key]
+	if !ok {
+		l = &groupLock{}
+		s.locks[key] = l
+	}
+	l.refs++
+	s.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		s.mu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(s.locks, key)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// get returns the thread of the group, or "" if it has none yet. A thread
+// unused for longer than the retention is treated as gone.
+func (s *threadStore) get(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.threads[key]
+	if !ok || time.Since(t.UpdatedAt) > s.retention {
+		return ""
+	}
+	t.UpdatedAt = time.Now()
+	return t.ThreadID
+}
+
+func (s *threadStore) record(key, webhookName, groupKey, threadID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.threads[key] = &groupThread{WebhookName: webhookName, GroupKey: groupKey, ThreadID: threadID, UpdatedAt: time.Now()}
+	for k, t := range s.threads {
+		if time.Since(t.UpdatedAt) > s.retention {
+			delete(s.threads, k)
+		}
+	}
+	s.save()
+}
+
+func (s *threadStore) forget(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.threads[key]; ok {
+		delete(s.threads, key)
+		s.save()
+	}
+}
+
+func (s *threadStore) save() {
+	if s.path == "" {
+		return
+	}
+	threads := make([]*groupThread, 0, len(s.threads))
+	for _, t := range s.threads {
+		threads = append(threads, t)
+	}
+	if err := writeJSONFile(s.path, threads); err != nil {
+		slog.Error("Failed to save thread IDs", "file", s.path, "error", err)
+	}
+}
+
+// trackedAlert is a firing alert as last reported by Alertmanager.
+type trackedAlert struct {
+	Fingerprint  string    `json:"fingerprint"`
+	Labels       KV        `json:"labels"`
+	Annotations  KV        `json:"annotations,omitempty"`
+	StartsAt     time.Time `json:"starts_at"`
+	GeneratorURL string    `json:"generator_url,omitempty"`
+	// Receiver and ExternalURL describe the notification that last
+	// reported the alert.
+	Receiver    string    `json:"receiver,omitempty"`
+	ExternalURL string    `json:"external_url,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	// Ack is set once someone acknowledged the alert in Discord.
+	Ack *alertAck `json:"ack,omitempty"`
+	// SilencedUntil is set when the alert was silenced from Discord.
+	SilencedUntil time.Time `json:"silenced_until,omitempty"`
+	// Escalated holds the keys of the escalation tiers already sent.
+	Escalated []string `json:"escalated_tiers,omitempty"`
+}
+
+// alert returns the firing Alertmanager alert a was recorded from.
+func (a *trackedAlert) alert() AlertManagerAlert {
+	return AlertManagerAlert{
+		Status:       "firing",
+		Labels:       a.Labels,
+		Annotations:  a.Annotations,
+		StartsAt:     a.StartsAt,
+		GeneratorURL: a.GeneratorURL,
+		Fingerprint:  a.Fingerprint,
+	}
+}
+
+// alertAck records who acknowledged an alert and when.
+type alertAck struct {
+	By     string    `json:"by"`
+	UserID string    `json:"user_id,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+// alertStore keeps the firing alerts by fingerprint, so actions taken in
+// Discord can find the labels of the alert they refer to. Acknowledgements,
+// silences and escalations are saved to the state directory right away;
+// the changes of notifications are saved at most every
+// alertStoreSaveDelay, and by flush at shutdown.
+type alertStore struct {
+	mu        sync.Mutex
+	path      string
+	retention time.Duration
+	alerts    map[string]*trackedAlert
+	// saveTimer is set while a delayed save is pending.
+	saveTimer *time.Timer
+}
+
+// openAlertStore loads the alerts saved in cfg.Directory, if any.
+func openAlertStore(cfg StateConfig) (*alertStore, error) {
+	s := &alertStore{retention: cfg.Retention, alerts: make(map[string]*trackedAlert)}
+	if cfg.Directory == "" {
+		return s, nil
+	}
+	if err := os.MkdirAll(cfg.Directory, 0o700); err != nil {
+		return nil, fmt.Errorf("creating state directory: %w", err)
+	}
+	s.path = filepath.Join(cfg.Directory, alertStoreFile)
+
+	var alerts []*trackedAlert
+	if err := readJSONFile(s.path, &alerts); err != nil {
+		return nil, err
+	}
+	for _, a := range alerts {
+		s.alerts[a.Fingerprint] = a
+	}
+	return s, nil
+}
+
+// update records the firing alerts of a notification and forgets the
+// resolved ones. Alerts not updated for longer than the retention are
+// forgotten too, in case their resolution was never sent.
+func (s *alertStore) update(alertManagerData *AlertManagerData) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changed := false
+	for _, alert := range alertManagerData.Alerts {
+		if alert.Fingerprint == "" {
+			continue
+		}
+		if alert.Status != "firing" {
+			if _, ok := s.alerts[alert.Fingerprint]; ok {
+				delete(s.alerts, alert.Fingerprint)
+				changed = true
+			}
+			continue
+		}
+		tracked := &trackedAlert{
+			Fingerprint:  alert.Fingerprint,
+			Labels:       alert.Labels,
+			Annotations:  alert.Annotations,
+			StartsAt:     alert.StartsAt,
+			GeneratorURL: alert.GeneratorURL,
+			Receiver:     alertManagerData.Receiver,
+			ExternalURL:  alertManagerData.ExternalURL,
+			UpdatedAt:    time.Now(),
+		}
+		if old, ok := s.alerts[alert.Fingerprint]; ok {
+			tracked.Ack, tracked.SilencedUntil, tracked.Escalated = old.Ack, old.SilencedUntil, old.Escalated
+		}
+		s.alerts[alert.Fingerprint] = tracked
+		changed = true
+	}
+	for fingerprint, a := range s.alerts {
+		if time.Since(a.UpdatedAt) > s.retention {
+			delete(s.alerts, fingerprint)
+			changed = true
+		}
+	}
+	if changed {
+		s.saveLater()
+	}
+}
+
+// acknowledge records that user acknowledged the firing alert with
+// fingerprint. It returns the alert's acknowledgement and whether it was
+// new, or ok false when the alert is not firing. An alert is acknowledged
+// only once.
+func (s *alertStore) acknowledge(fingerprint string, user discordUser) (ack alertAck, isNew bool, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.alerts[fingerprint]
+	if !ok {
+		return alertAck{}, false, false
+	}
+	if a.Ack != nil {
+		return *a.Ack, false, true
+	}
+	a.Ack = &alertAck{By: user.name(), UserID: user.ID, At: time.Now()}
+	s.save()
+	return *a.Ack, true, true
+}
+
+// silence records that the firing alerts matched by matchers were silenced
+// until the given time.
+func (s *alertStore) silence(matchers labelMatchers, until time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changed := false
+	for _, a := range s.alerts {
+		if matchers.matches(a.Labels) {
+			a.SilencedUntil = until
+			changed = true
+		}
+	}
+	if changed {
+		s.save()
+	}
+}
+
+// escalate returns copies of the alerts that are due for an escalation
+// tier at now, each with the tier, and records them as escalated.
+// Acknowledged alerts and alerts silenced from Discord are not escalated.
+func (s *alertStore) escalate(tiers []escalationTier, now time.Time) []escalation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []escalation
+	for _, a := range s.alerts {
+		if a.Ack != nil || now.Before(a.SilencedUntil) {
+			continue
+		}
+		for i, tier := range tiers {
+			if slices.Contains(a.Escalated, tier.key) || now.Sub(a.StartsAt) < tier.after || !tier.matchers.matches(a.Labels) {
+				continue
+			}
+			a.Escalated = append(a.Escalated, tier.key)
+			due = append(due, escalation{alert: *a, tier: tier, number: i + 1})
+		}
+	}
+	if len(due) > 0 {
+		s.save()
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].alert.StartsAt.Before(due[j].alert.StartsAt) })
+	return due
+}
+
+// acknowledged returns copies of the acknowledged firing alerts.
+func (s *alertStore) acknowledged() []trackedAlert {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var acked []trackedAlert
+	for _, a := range s.alerts {
+		if a.Ack != nil {
+			acked = append(acked, *a)
+		}
+	}
+	sort.Slice(acked, func(i, j int) bool { return acked[i].Ack.At.Before(acked[j].Ack.At) })
+	return acked
+}
+
+// get returns a copy of the firing alert with fingerprint.
+func (s *alertStore) get(fingerprint string) (trackedAlert, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.alerts[fingerprint]
+	if !ok {
+		return trackedAlert{}, false
+	}
+	return *a, true
+}
+
+// saveLater schedules a save after alertStoreSaveDelay unless one is
+// already pending. s.mu must be held.
+func (s *alertStore) saveLater() {
+	if s.path == "" || s.saveTimer != nil {
+		return
+	}
+	s.saveTimer = time.AfterFunc(alertStoreSaveDelay, s.flush)
+}
+
+// flush saves the store if a delayed save is pending.
+func (s *alertStore) flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.saveTimer != nil {
+		s.save()
+	}
+}
+
+// save writes the store, including the changes of a pending delayed save.
+// s.mu must be held.
+func (s *alertStore) save() {
+	if s.saveTimer != nil {
+		s.saveTimer.Stop()
+		s.saveTimer = nil
+	}
+	if s.path == "" {
+		return
+	}
+	alerts := make([]*trackedAlert, 0, len(s.alerts))
+	for _, a := range s.alerts {
+		alerts = append(alerts, a)
+	}
+	if err := writeJSONFile(s.path, alerts); err != nil {
+		slog.Error("Failed to save alerts", "file", s.path, "error", err)
+	}
+}
+
+// readJSONFile decodes the JSON file at path into v. A missing file leaves
+// v untouched.
+func readJSONFile(path string, v interface{}) error {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return nil
+}
+
+// writeJSONFile replaces the file at path with v encoded as JSON. It writes
+// a temporary file first, so a crash never leaves a truncated file behind.
+func writeJSONFile(path string, v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(raw); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestThreadStoreLockIsDroppedWhenUnused(t *testing.T) {
+	s, err := openThreadStore(StateConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var holders, overlapped atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock := s.lock(threadStoreKey("default", "group"))
+			if holders.Add(1) > 1 {
+				overlapped.Add(1)
+			}
+			holders.Add(-1)
+			unlock()
+		}()
+	}
+	wg.Wait()
+
+	if overlapped.Load() > 0 {
+		t.Error("deliveries of one group held the lock at the same time")
+	}
+	unlock := s.lock(threadStoreKey("default", "other"))
+	unlock()
+	if len(s.locks) != 0 {
+		t.Errorf("%d group locks left after every delivery finished", len(s.locks))
+	}
+}
+
+func TestAlertStoreSavesNotificationsLater(t *testing.T) {
+	cfg := StateConfig{Directory: t.TempDir(), Retention: time.Hour}
+	s, err := openAlertStore(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := func() int {
+		t.Helper()
+		reopened, err := openAlertStore(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(reopened.alerts)
+	}
+
+	for _, fingerprint := range []string{"a", "b"} {
+		s.update(&AlertManagerData{Alerts: AlertManagerAlerts{{Status: "firing", Fingerprint: fingerprint}}})
+	}
+	if n := saved(); n != 0 {
+		t.Errorf("%d alerts saved right after the notifications, want the save delayed", n)
+	}
+	s.flush()
+	if n := saved(); n != 2 {
+		t.Errorf("%d alerts saved after flush, want 2", n)
+	}
+
+	// Acknowledgements are saved at once, with any pending notification.
+	s.update(&AlertManagerData{Alerts: AlertManagerAlerts{{Status: "firing", Fingerprint: "c"}}})
+	if _, _, ok := s.acknowledge("a", discordUser{Username: "alice"}); !ok {
+		t.Fatal("alert a is not firing")
+	}
+	if n := saved(); n != 3 {
+		t.Errorf("%d alerts saved after an acknowledgement, want 3", n)
+	}
+	if s.saveTimer != nil {
+		t.Error("a delayed save is still pending after the store was saved")
+	}
+}
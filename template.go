@@ -0,0 +1,303 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// templateData is the value templates are executed with. The alert's fields
+// are promoted, so templates can use .Labels, .Annotations, .Status,
+// .StartsAt and so on, while .Data holds the whole Alertmanager payload.
+type templateData struct {
+	AlertManagerAlert
+	Data *AlertManagerData
+}
+
+// templateFuncs are the helpers available to templates, modelled after
+// Alertmanager's template functions.
+var templateFuncs = template.FuncMap{
+	"toUpper":   strings.ToUpper,
+	"toLower":   strings.ToLower,
+	"trimSpace": strings.TrimSpace,
+	"join": func(sep string, s []string) string {
+		return strings.Join(s, sep)
+	},
+	"SortedPairs": func(kv KV) Pairs {
+		return kv.SortedPairs()
+	},
+	"truncate": func(n int, s string) string {
+		return truncateString(s, n)
+	},
+	"humanizeDuration": humanizeDuration,
+	"since":            time.Since,
+	"match":            regexp.MatchString,
+	"reReplaceAll": func(pattern, repl, text string) (string, error) {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return "", err
+		}
+		return re.ReplaceAllString(text, repl), nil
+	},
+}
+
+// humanizeDuration formats a duration, or a number of seconds like
+// Prometheus' humanizeDuration, as e.g. "1h 2m 3s".
+func humanizeDuration(v interface{}) (string, error) {
+	var seconds float64
+	switch d := v.(type) {
+	case time.Duration:
+		seconds = d.Seconds()
+	case int:
+		seconds = float64(d)
+	case int64:
+		seconds = float64(d)
+	case float64:
+		seconds = d
+	case string:
+		parsed, err := strconv.ParseFloat(d, 64)
+		if err != nil {
+			return "", err
+		}
+		seconds = parsed
+	default:
+		return "", fmt.Errorf("humanizeDuration: unsupported type %T", v)
+	}
+
+	if math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+		return fmt.Sprintf("%.4g", seconds), nil
+	}
+	if math.Abs(seconds) < 1 {
+		return time.Duration(seconds * float64(time.Second)).String(), nil
+	}
+
+	sign := ""
+	if seconds < 0 {
+		sign = "-"
+		seconds = -seconds
+	}
+	total := int64(seconds)
+	days, hours, minutes, secs := total/86400, total/3600%24, total/60%60, total%60
+
+	var parts []string
+	if days > 0 {
+		parts = append(parts, fmt.Sprintf("%dd", days))
+	}
+	if hours > 0 {
+		parts = append(parts, fmt.Sprintf("%dh", hours))
+	}
+	if minutes > 0 {
+		parts = append(parts, fmt.Sprintf("%dm", minutes))
+	}
+	if secs > 0 || len(parts) == 0 {
+		parts = append(parts, fmt.Sprintf("%ds", secs))
+	}
+	return sign + strings.Join(parts, " "), nil
+}
+
+type fieldTemplate struct {
+	name   *template.Template
+	value  *template.Template
+	inline bool
+}
+
+// embedTemplates holds the parsed user templates. A nil template keeps the
+// built-in rendering for that part of the embed.
+type embedTemplates struct {
+	title       *template.Template
+	description *template.Template
+	content     *template.Template
+	color       *template.Template
+	footer      *template.Template
+	fields      []fieldTemplate
+}
+
+// newEmbedTemplates parses cfg and executes every template against a sample
+// alert, so mistakes are reported at startup instead of at alert time.
+func newEmbedTemplates(cfg TemplatesConfig) (*embedTemplates, error) {
+	t := &embedTemplates{}
+	parse := func(name, text string) (*template.Template, error) {
+		if strings.TrimSpace(text) == "" {
+			return nil, nil
+		}
+		tmpl, err := template.New(name).Option("missingkey=zero").Funcs(templateFuncs).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("templates.%s: %w", name, err)
+		}
+		return tmpl, nil
+	}
+
+	var err error
+	if t.title, err = parse("title", cfg.Title); err != nil {
+		return nil, err
+	}
+	if t.description, err = parse("description", cfg.Description); err != nil {
+		return nil, err
+	}
+	if t.content, err = parse("content", cfg.Content); err != nil {
+		return nil, err
+	}
+	if t.color, err = parse("color", cfg.Color); err != nil {
+		return nil, err
+	}
+	if t.footer, err = parse("footer", cfg.Footer); err != nil {
+		return nil, err
+	}
+	for i, fc := range cfg.Fields {
+		ft := fieldTemplate{inline: fc.Inline}
+		if ft.name, err = parse(fmt.Sprintf("fields[%d].name", i), fc.Name); err != nil {
+			return nil, err
+		}
+		if ft.value, err = parse(fmt.Sprintf("fields[%d].value", i), fc.Value); err != nil {
+			return nil, err
+		}
+		if ft.name == nil || ft.value == nil {
+			return nil, fmt.Errorf("templates.fields[%d]: name and value are required", i)
+		}
+		t.fields = append(t.fields, ft)
+	}
+
+	if err := t.check(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// check renders every template with a sample alert.
+func (t *embedTemplates) check() error {
+	now := time.Now()
+	alert := AlertManagerAlert{
+		Status:       "firing",
+		Labels:       KV{AlertNameLabel: "TemplateCheck", "severity": "warning", "instance": "localhost:9100"},
+		Annotations:  KV{"summary": "Template check", "description": "Sample alert used to validate templates"},
+		StartsAt:     now.Add(-5 * time.Minute),
+		GeneratorURL: "http://prometheus.example/graph",
+		Fingerprint:  "0000000000000000",
+	}
+	data := &AlertManagerData{
+		Receiver:     "discord",
+		Status:       "firing",
+		Alerts:       AlertManagerAlerts{alert},
+		GroupLabels:  KV{AlertNameLabel: "TemplateCheck"},
+		CommonLabels: alert.Labels,
+		ExternalURL:  "http://alertmanager.example",
+	}
+
+	templates := []*template.Template{t.title, t.description, t.content, t.color, t.footer}
+	for _, ft := range t.fields {
+		templates = append(templates, ft.name, ft.value)
+	}
+	for _, tmpl := range templates {
+		if tmpl == nil {
+			continue
+		}
+		if _, err := execTemplate(tmpl, data, &alert); err != nil {
+			return fmt.Errorf("templates.%s: %w", tmpl.Name(), err)
+		}
+	}
+	if t.color != nil {
+		out, _ := execTemplate(t.color, data, &alert)
+		if _, err := parseColor(out); err != nil {
+			return fmt.Errorf("templates.color: %w", err)
+		}
+	}
+	return nil
+}
+
+func execTemplate(tmpl *template.Template, data *AlertManagerData, alert *AlertManagerAlert) (string, error) {
+	var out strings.Builder
+	if err := tmpl.Execute(&out, templateData{AlertManagerAlert: *alert, Data: data}); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+// render executes tmpl and reports whether its output should be used. On
+// error the built-in rendering is kept.
+func (t *embedTemplates) render(tmpl *template.Template, data *AlertManagerData, alert *AlertManagerAlert) (string, bool) {
+	if tmpl == nil {
+		return "", false
+	}
+	out, err := execTemplate(tmpl, data, alert)
+	if err != nil {
+		slog.Warn("Failed to render template, using built-in layout", "template", tmpl.Name(), "fingerprint", alert.Fingerprint, "error", err)
+		return "", false
+	}
+	return out, true
+}
+
+// apply overrides the parts of embed that have a template, truncating them
+// to the limits of formatting.
+func (t *embedTemplates) apply(embed *DiscordEmbed, data *AlertManagerData, alert *AlertManagerAlert, formatting FormattingConfig) {
+	if title, ok := t.render(t.title, data, alert); ok && title != "" {
+		embed.Title = truncateString(title, formatting.MaxTitleLength)
+	}
+	if description, ok := t.render(t.description, data, alert); ok {
+		embed.Description = truncateString(description, formatting.MaxDescriptionLength)
+	}
+	if out, ok := t.render(t.color, data, alert); ok {
+		if color, err := parseColor(out); err == nil {
+			embed.Color = color
+		} else {
+			slog.Warn("Template rendered an invalid color, using built-in color", "color", out, "fingerprint", alert.Fingerprint)
+		}
+	}
+	if footer, ok := t.render(t.footer, data, alert); ok {
+		if footer == "" {
+			embed.Footer = nil
+		} else {
+			embed.Footer = &DiscordEmbedFooter{Text: truncateString(footer, 2048)}
+			currentTime := time.Now()
+			embed.Timestamp = &currentTime
+		}
+	}
+
+	if len(t.fields) == 0 {
+		return
+	}
+	fields := DiscordEmbedFields{}
+	for _, ft := range t.fields {
+		name, okName := t.render(ft.name, data, alert)
+		value, okValue := t.render(ft.value, data, alert)
+		if !okName || !okValue {
+			// Keep the built-in fields rather than a partial set.
+			return
+		}
+		// Fields that render empty are left out, so templates can
+		// include them conditionally.
+		if name == "" || value == "" {
+			continue
+		}
+		fields = append(fields, DiscordEmbedField{
+			Name:   truncateString(name, 256),
+			Value:  truncateString(value, formatting.MaxFieldValueLength),
+			Inline: ft.inline,
+		})
+	}
+	embed.Fields = fields
+}
+
+// renderContent returns the message content for alert, or "" when no
+// content template is configured.
+func (t *embedTemplates) renderContent(data *AlertManagerData, alert *AlertManagerAlert) string {
+	content, _ := t.render(t.content, data, alert)
+	return content
+}
+
+// parseColor accepts decimal, 0x-prefixed or #-prefixed hex colors.
+func parseColor(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "#") {
+		s = "0x" + s[1:]
+	}
+	color, err := strconv.ParseInt(s, 0, 32)
+	if err != nil || color < 0 || color > 0xFFFFFF {
+		return 0, fmt.Errorf("invalid color %q", s)
+	}
+	return int(color), nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// failsForDB passes the startup check but fails to render for team="db".
+const failsForDB = `{{ if eq .Labels.team "db" }}{{ humanizeDuration "soon" }}{{ end }}`
+
+func TestEmbedTemplatesApply(t *testing.T) {
+	builtIn := DiscordEmbed{
+		Title:       "built-in title",
+		Description: "built-in description",
+		Color:       0x00ff00,
+		Fields:      DiscordEmbedFields{{Name: "Labels", Value: "team=db"}},
+		Footer:      &DiscordEmbedFooter{Text: "built-in footer"},
+	}
+	withTitle := builtIn
+	withTitle.Title = "DB alert"
+	withDescription := builtIn
+	withDescription.Description = "summary"
+	withColor := builtIn
+	withColor.Color = 0xd00000
+	withFields := builtIn
+	withFields.Fields = DiscordEmbedFields{{Name: "Team", Value: "db", Inline: true}}
+	withoutFooter := builtIn
+	withoutFooter.Footer = nil
+
+	for _, tc := range []struct {
+		name string
+		cfg  TemplatesConfig
+		want DiscordEmbed
+	}{
+		{"no templates", TemplatesConfig{}, builtIn},
+		{"title", TemplatesConfig{Title: `{{ .Labels.team | toUpper }} alert`}, withTitle},
+		{"empty title keeps the built-in one", TemplatesConfig{Title: `{{ .Labels.missing }}`}, builtIn},
+		{"failing title", TemplatesConfig{Title: failsForDB + "title"}, builtIn},
+		{"description", TemplatesConfig{Description: `{{ .Annotations.summary }}`}, withDescription},
+		{"failing description", TemplatesConfig{Description: failsForDB}, builtIn},
+		{"color", TemplatesConfig{Color: `#d00000`}, withColor},
+		{"invalid color", TemplatesConfig{Color: `{{ if eq .Labels.team "db" }}red{{ else }}#d00000{{ end }}`}, builtIn},
+		{"failing color", TemplatesConfig{Color: failsForDB + "#d00000"}, builtIn},
+		{"fields", TemplatesConfig{Fields: []FieldTemplateConfig{
+			{Name: "Team", Value: `{{ .Labels.team }}`, Inline: true},
+			{Name: "Owner", Value: `{{ .Labels.owner }}`},
+		}}, withFields},
+		{"failing field keeps all built-in fields", TemplatesConfig{Fields: []FieldTemplateConfig{
+			{Name: "Team", Value: `{{ .Labels.team }}`},
+			{Name: "Broken", Value: failsForDB + "x"},
+		}}, builtIn},
+		{"empty footer", TemplatesConfig{Footer: `{{ .Labels.missing }}`}, withoutFooter},
+		{"failing footer", TemplatesConfig{Footer: failsForDB + "footer"}, builtIn},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			templates, err := newEmbedTemplates(tc.cfg)
+			if err != nil {
+				t.Fatalf("newEmbedTemplates: %v", err)
+			}
+			alert := &AlertManagerAlert{
+				Status:      "firing",
+				Labels:      KV{"alertname": "DiskFull", "team": "db"},
+				Annotations: KV{"summary": "summary"},
+			}
+			embed := builtIn
+			templates.apply(&embed, &AlertManagerData{Alerts: AlertManagerAlerts{*alert}}, alert, defaultConfig().Discord.Formatting)
+			embed.Timestamp = nil
+			if !reflect.DeepEqual(embed, tc.want) {
+				t.Errorf("embed = %+v, want %+v", embed, tc.want)
+			}
+		})
+	}
+}
+
+func TestNewEmbedTemplatesErrors(t *testing.T) {
+	for name, cfg := range map[string]TemplatesConfig{
+		"syntax error":        {Title: `{{ .Labels.alertname`},
+		"unknown function":    {Title: `{{ shout .Labels.alertname }}`},
+		"fails on sample":     {Description: `{{ humanizeDuration "soon" }}`},
+		"invalid color":       {Color: `red`},
+		"field without value": {Fields: []FieldTemplateConfig{{Name: "Team"}}},
+	} {
+		if _, err := newEmbedTemplates(cfg); err == nil {
+			t.Errorf("%s: newEmbedTemplates accepted the templates", name)
+		}
+	}
+}
+
+func TestParseColor(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		want int
+		ok   bool
+	}{
+		{"#d00000", 0xd00000, true},
+		{"0xFFAA00", 0xffaa00, true},
+		{"16711680", 0xff0000, true},
+		{" #000000 ", 0, true},
+		{"#1000000", 0, false},
+		{"-1", 0, false},
+		{"red", 0, false},
+	} {
+		got, err := parseColor(tc.s)
+		if got != tc.want || (err == nil) != tc.ok {
+			t.Errorf("parseColor(%q) = %#x, %v; want %#x, ok %v", tc.s, got, err, tc.want, tc.ok)
+		}
+	}
+}
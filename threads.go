@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// threadName names the thread of an Alertmanager group after its alerts.
+// Discord allows at most 100 characters.
+func threadName(alertManagerData *AlertManagerData) string {
+	name := strings.TrimSpace(strings.SplitN(getAlertName(alertManagerData), "\n", 2)[0])
+	if name == "" {
+		name = "Alerts"
+	}
+	return truncateString(name, 100)
+}
+
+// discordAPIURL returns the URL of a Discord API endpoint on the host that
+// serves webhookURL.
+func discordAPIURL(webhookURL string, path string) (string, error) {
+	u, err := url.Parse(webhookURL)
+	if err != nil {
+		return "", err
+	}
+	return (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/api/v10" + path}).String(), nil
+}
+
+// startMessageThread starts a thread on the message messageID posted for
+// d to webhookURL in channelID and returns the thread's ID. Webhooks cannot
+// do this, so the request is made with the bot token.
+func startMessageThread(cfg *Config, d *delivery, webhookURL, channelID, messageID string) (string, error) {
+	endpoint, err := discordAPIURL(webhookURL, "/channels/"+channelID+"/messages/"+messageID+"/threads")
+	if err != nil {
+		return "", err
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"name":                  d.ThreadName,
+		"auto_archive_duration": cfg.Discord.Threads.AutoArchiveDuration,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	header := http.Header{"Authorization": {"Bot " + cfg.Discord.BotToken}}
+	responseData, err := discordRequest(cfg, http.MethodPost, d.WebhookName, endpoint, header, body)
+	if err != nil {
+		return "", err
+	}
+	var thread struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(responseData, &thread); err != nil || thread.ID == "" {
+		return "", fmt.Errorf("unexpected response starting thread: %s", responseData)
+	}
+	return thread.ID, nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// tlsReloadInterval is how often the certificate files are checked for
+// changes at most.
+const tlsReloadInterval = 5 * time.Second
+
+var tlsVersions = map[string]uint16{
+	"TLS10": tls.VersionTLS10,
+	"TLS11": tls.VersionTLS11,
+	"TLS12": tls.VersionTLS12,
+	"TLS13": tls.VersionTLS13,
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"NoClientCert":               tls.NoClientCert,
+	"RequestClientCert":          tls.RequestClientCert,
+	"RequireAnyClientCert":       tls.RequireAnyClientCert,
+	"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+	"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+}
+
+// enabled reports whether the listener serves HTTPS.
+func (c TLSConfig) enabled() bool {
+	return c.CertFile != ""
+}
+
+// validate checks the TLS settings without reading the files.
+func (c TLSConfig) validate() error {
+	if !c.enabled() {
+		if c.KeyFile != "" || c.ClientCAFile != "" {
+			return fmt.Errorf("server.tls.cert_file is required for key_file and client_ca_file")
+		}
+		return nil
+	}
+	if c.KeyFile == "" {
+		return fmt.Errorf("server.tls.key_file is required with cert_file")
+	}
+	if _, ok := tlsVersions[c.MinVersion]; !ok {
+		return fmt.Errorf("server.tls.min_version must be TLS10, TLS11, TLS12 or TLS13, got %q", c.MinVersion)
+	}
+	if c.ClientAuthType != "" {
+		if _, ok := clientAuthTypes[c.ClientAuthType]; !ok {
+			return fmt.Errorf("server.tls.client_auth_type %q is unknown", c.ClientAuthType)
+		}
+	}
+	verifies := c.ClientAuthType == "VerifyClientCertIfGiven" || c.ClientAuthType == "RequireAndVerifyClientCert"
+	if verifies && c.ClientCAFile == "" {
+		return fmt.Errorf("server.tls.client_auth_type %q requires client_ca_file", c.ClientAuthType)
+	}
+	if _, err := cipherSuiteIDs(c.CipherSuites); err != nil {
+		return err
+	}
+	return nil
+}
+
+// cipherSuiteIDs maps cipher suite names to their IDs. Only secure suites
+// are accepted; TLS 1.3 suites are not configurable.
+func cipherSuiteIDs(names []string) ([]uint16, error) {
+	var ids []uint16
+	for _, name := range names {
+		found := false
+		for _, suite := range tls.CipherSuites() {
+			if suite.Name == name {
+				ids = append(ids, suite.ID)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("server.tls.cipher_suites: %q is not a supported cipher suite", name)
+		}
+	}
+	return ids, nil
+}
+
+// tlsFiles holds the certificate and client CAs loaded from the files of
+// a TLSConfig, reloading them when the files change.
+type tlsFiles struct {
+	cfg TLSConfig
+
+	mu          sync.Mutex
+	checked     time.Time
+	modified    map[string]time.Time
+	certificate *tls.Certificate
+	clientCAs   *x509.CertPool
+}
+
+// load reads the files if any of them changed since they were last read.
+// On failure the previously loaded files stay in use.
+func (f *tlsFiles) load() error {
+	files := []string{f.cfg.CertFile, f.cfg.KeyFile}
+	if f.cfg.ClientCAFile != "" {
+		files = append(files, f.cfg.ClientCAFile)
+	}
+	modified := make(map[string]time.Time)
+	changed := f.certificate == nil
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		modified[file] = info.ModTime()
+		changed = changed || !info.ModTime().Equal(f.modified[file])
+	}
+	if !changed {
+		return nil
+	}
+
+	certificate, err := tls.LoadX509KeyPair(f.cfg.CertFile, f.cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("loading server.tls certificate: %w", err)
+	}
+	var clientCAs *x509.CertPool
+	if f.cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(f.cfg.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("loading server.tls.client_ca_file: %w", err)
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("server.tls.client_ca_file %s contains no certificates", f.cfg.ClientCAFile)
+		}
+	}
+	f.certificate, f.clientCAs, f.modified = &certificate, clientCAs, modified
+	return nil
+}
+
+// current returns the loaded certificate and client CAs, reloading them
+// at most every tlsReloadInterval.
+func (f *tlsFiles) current() (*tls.Certificate, *x509.CertPool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if time.Since(f.checked) >= tlsReloadInterval {
+		f.checked = time.Now()
+		previous := f.certificate
+		if err := f.load(); err != nil {
+			slog.Error("Failed to reload TLS files, keeping the previous ones", "error", err)
+		} else if previous != nil && f.certificate != previous {
+			slog.Info("Reloaded TLS certificate", "cert_file", f.cfg.CertFile)
+		}
+	}
+	return f.certificate, f.clientCAs
+}
+
+// newServerTLSConfig returns the TLS configuration of the listener. The
+// files are read once here, so errors surface at startup, and re-read on
+// change afterwards.
+func newServerTLSConfig(cfg TLSConfig) (*tls.Config, error) {
+	files := &tlsFiles{cfg: cfg, checked: time.Now()}
+	if err := files.load(); err != nil {
+		return nil, err
+	}
+	cipherSuites, err := cipherSuiteIDs(cfg.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+	clientAuth := tls.NoClientCert
+	if cfg.ClientAuthType != "" {
+		clientAuth = clientAuthTypes[cfg.ClientAuthType]
+	} else if cfg.ClientCAFile != "" {
+		clientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	base := &tls.Config{
+		MinVersion:   tlsVersions[cfg.MinVersion],
+		CipherSuites: cipherSuites,
+		ClientAuth:   clientAuth,
+	}
+	return &tls.Config{
+		MinVersion: base.MinVersion,
+		ClientAuth: base.ClientAuth,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			certificate, clientCAs := files.current()
+			clientConfig := base.Clone()
+			clientConfig.Certificates = []tls.Certificate{*certificate}
+			clientConfig.ClientCAs = clientCAs
+			return clientConfig, nil
+		},
+	}, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/rivo/uniseg"
+)
+
+const ellipsis = "..."
+
+// markdownLinkRe matches a markdown link such as [text](https://example.com).
+var markdownLinkRe = regexp.MustCompile(`^\[[^\]\n]*\]\([^)\s]*\)`)
+
+// graphemeCut returns the byte offset of the longest prefix of s made of
+// whole grapheme clusters and at most n characters, and whether s is longer
+// than that. When preferSpace is set, a cut after whitespace in the second
+// half of the prefix is preferred.
+func graphemeCut(s string, n int, preferSpace bool) (int, bool) {
+	cut, chars, spaceCut := 0, 0, 0
+	state := -1
+	rest := s
+	for rest != "" {
+		var cluster string
+		cluster, rest, _, state = uniseg.FirstGraphemeClusterInString(rest, state)
+		length := len([]rune(cluster))
+		if chars+length > n {
+			if preferSpace && spaceCut > 0 && spaceCut > cut/2 {
+				return spaceCut, true
+			}
+			return cut, true
+		}
+		chars += length
+		cut += len(cluster)
+		if strings.TrimFunc(cluster, unicode.IsSpace) == "" {
+			spaceCut = cut
+		}
+	}
+	return cut, false
+}
+
+// markdownSafeCut moves cut back to the start of a markdown code span, link
+// or URL that it would otherwise break. It returns cut unchanged when the
+// construct starts at the beginning of s, as nothing would be left.
+func markdownSafeCut(s string, cut int) int {
+	for i := 0; i < cut; {
+		end := 0
+		switch {
+		case s[i] == '`':
+			n := len(s[i:]) - len(strings.TrimLeft(s[i:], "`"))
+			closing := strings.Index(s[i+n:], s[i:i+n])
+			if closing < 0 {
+				// An unmatched backtick run is literal text.
+				i += n
+				continue
+			}
+			end = i + n + closing + n
+		case s[i] == '[':
+			if loc := markdownLinkRe.FindStringIndex(s[i:]); loc != nil {
+				end = i + loc[1]
+			}
+		case strings.HasPrefix(s[i:], "http://") || strings.HasPrefix(s[i:], "https://"):
+			end = i + strings.IndexFunc(s[i:], unicode.IsSpace)
+			if end < i {
+				end = len(s)
+			}
+		}
+		if end == 0 {
+			i++
+			continue
+		}
+		if end > cut {
+			if i == 0 {
+				return cut
+			}
+			return i
+		}
+		i = end
+	}
+	return cut
+}
+
+// truncateText shortens s to at most maxLen characters, ending it with
+// "...". It counts characters rather than bytes, never splits a multi-byte
+// character or an emoji sequence, and cuts before a markdown code span,
+// link or URL instead of through it.
+func truncateText(s string, maxLen int) (string, bool) {
+	if _, long := graphemeCut(s, maxLen, false); !long {
+		return s, false
+	}
+	if maxLen <= len(ellipsis) {
+		cut, _ := graphemeCut(s, maxLen, false)
+		return s[:cut], true
+	}
+	cut, _ := graphemeCut(s, maxLen-len(ellipsis), true)
+	cut = markdownSafeCut(s, cut)
+	return strings.TrimRightFunc(s[:cut], unicode.IsSpace) + ellipsis, true
+}
+
+// truncateString is truncateText for text sent to Discord; truncations are
+// counted in the truncations_total metric.
+func truncateString(s string, maxLen int) string {
+	truncated, ok := truncateText(s, maxLen)
+	if ok {
+		truncations.Inc()
+	}
+	return truncated
+}
+
+// splitText splits s after at most n characters, preferring the last line
+// break or space in the second half of that range. Like truncateText it
+// keeps characters, emoji sequences and markdown constructs whole where it
+// can.
+func splitText(s string, n int) (head, tail string) {
+	cut, long := graphemeCut(s, n, true)
+	if !long {
+		return s, ""
+	}
+	if safe := markdownSafeCut(s, cut); safe > 0 {
+		cut = safe
+	}
+	if cut == 0 {
+		// A single cluster longer than n characters; split it anyway.
+		cut = len(string([]rune(s)[:n]))
+	}
+	return s[:cut], s[cut:]
+}
@@ -0,0 +1,76 @@
+package main
+
+import "testing"
+
+const (
+	family   = "👨‍👩‍👧" // three emoji joined by ZWJ, 5 code points
+	thumbsUp = "👍🏽"    // emoji with skin tone modifier, 2 code points
+	flagDE   = "🇩🇪"    // regional indicator pair, 2 code points
+)
+
+func TestTruncateText(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		s      string
+		maxLen int
+		want   string
+		cut    bool
+	}{
+		{"short", "hello", 10, "hello", false},
+		{"exact length", "hello", 5, "hello", false},
+		{"no spaces", "abcdefghij", 8, "abcde...", true},
+		{"prefers a space", "hello world again", 12, "hello...", true},
+		{"counts characters, not bytes", "héllo wörld", 8, "héllo...", true},
+		{"exact length in characters", "wörld", 5, "wörld", false},
+
+		{"ZWJ sequence is not split", "ab" + family + "cd", 8, "ab...", true},
+		{"ZWJ sequence that fits", "ab" + family + "cdefgh", 10, "ab" + family + "...", true},
+		{"skin tone is not split", "abcd" + thumbsUp + "efgh", 8, "abcd...", true},
+		{"flag is not split", "abcd" + flagDE + "efgh", 8, "abcd...", true},
+		{"no room for an ellipsis", "abcdef", 3, "abc", true},
+		{"no room for an emoji", thumbsUp + "x", 1, "", true},
+
+		{"code span is kept whole", "see `some code here` now", 15, "see...", true},
+		{"unmatched backticks are text", "a `b c d e f g h", 10, "a `b c...", true},
+		{"longer backtick run", "x ``a ` b`` y z w v u", 10, "x...", true},
+		{"link at the cut", "details [runbook](https://example.com/rb) more", 20, "details...", true},
+		{"link before the cut", "[a](http://x.io) and more words here", 20, "[a](http://x.io)...", true},
+		{"link starting the text is cut anyway", "[runbook](https://example.com/a/very/long/path)", 10, "[runboo...", true},
+		{"bare URL", "see https://example.com/path/to/thing for details", 20, "see...", true},
+		{"bare URL at the end", "see https://example.com/path/to/thing", 20, "see...", true},
+		{"URL before the cut", "https://x.io is down for everyone", 20, "https://x.io is...", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, cut := truncateText(tc.s, tc.maxLen)
+			if got != tc.want || cut != tc.cut {
+				t.Errorf("truncateText(%q, %d) = %q, %v; want %q, %v", tc.s, tc.maxLen, got, cut, tc.want, tc.cut)
+			}
+			if n := len([]rune(got)); n > tc.maxLen {
+				t.Errorf("result has %d characters, more than %d", n, tc.maxLen)
+			}
+		})
+	}
+}
+
+func TestSplitText(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		s          string
+		n          int
+		head, tail string
+	}{
+		{"fits", "hello", 10, "hello", ""},
+		{"at a space", "hello world", 8, "hello ", "world"},
+		{"ZWJ sequence is not split", "ab" + family, 4, "ab", family},
+		{"code span moves to the tail", "hello `abc def` ghi", 12, "hello ", "`abc def` ghi"},
+		{"link moves to the tail", "see [a](https://example.com) x", 12, "see ", "[a](https://example.com) x"},
+		{"single long cluster", family, 3, "👨‍👩", "‍👧"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			head, tail := splitText(tc.s, tc.n)
+			if head != tc.head || tail != tc.tail {
+				t.Errorf("splitText(%q, %d) = %q, %q; want %q, %q", tc.s, tc.n, head, tail, tc.head, tc.tail)
+			}
+		})
+	}
+}